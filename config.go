@@ -0,0 +1,15 @@
+package blog
+
+import _ "embed"
+
+// Site settings shared by the functions and scripts. They're read by the
+// netlify/config package, which also applies the environment's overrides.
+//
+//go:embed site.toml
+var SiteTOML []byte
+
+// The actors' signing keys, kept apart from the site settings as
+// scripts/rotate_key rewrites them
+//
+//go:embed keys.toml
+var KeysTOML []byte
@@ -0,0 +1,307 @@
+package ap
+
+import (
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/maxbanister/blog/netlify/config"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// LocalActor is an account this site federates as. Each has its own inbox,
+// outbox, followers and signing key.
+type LocalActor struct {
+	config.Actor
+	// set on InstanceActor only
+	instance bool
+}
+
+// LocalActors are the accounts posts can be published as, by the actor named
+// in their front matter, in the order the site configuration lists them. The
+// first is the default: posts that don't name one and the site-wide
+// /ap/inbox, /ap/outbox and /ap/followers belong to it.
+var LocalActors = localActors(config.Site.Actors)
+
+func localActors(actors []config.Actor) []*LocalActor {
+	local := make([]*LocalActor, len(actors))
+	for i, a := range actors {
+		local[i] = &LocalActor{Actor: a}
+	}
+	return local
+}
+
+// InstanceActor is the site itself, an Application at /ap/actor. It has no
+// posts or followers, and only signs the site's own fetches.
+var InstanceActor = instanceActor(config.Site.InstanceActor)
+
+func instanceActor(actor config.Actor) *LocalActor {
+	if actor.ProfilePage == "" {
+		actor.ProfilePage = "/"
+	}
+	return &LocalActor{Actor: actor, instance: true}
+}
+
+// SiteSigner signs the requests the site makes on its own account rather
+// than an actor's: the instance actor, once it has a key, else the default
+// actor
+func SiteSigner() *LocalActor {
+	if len(InstanceActor.Keys) > 0 {
+		return InstanceActor
+	}
+	return DefaultActor()
+}
+
+func DefaultActor() *LocalActor {
+	return LocalActors[0]
+}
+
+// FindLocalActor looks an actor up by username, returning nil if there's no
+// such actor
+func FindLocalActor(username string) *LocalActor {
+	for _, a := range LocalActors {
+		if strings.EqualFold(a.Username, username) {
+			return a
+		}
+	}
+	return nil
+}
+
+// LocalActorByID finds the actor an ID, or one of its key IDs, belongs to
+func LocalActorByID(id string) *LocalActor {
+	id, _, _ = strings.Cut(id, "#")
+	for _, a := range LocalActors {
+		if id == a.ID() {
+			return a
+		}
+	}
+	return nil
+}
+
+// keyOwner finds which of our actors, the instance actor included, an ID or
+// key ID belongs to
+func keyOwner(id string) *LocalActor {
+	if local := LocalActorByID(id); local != nil {
+		return local
+	}
+	if id, _, _ := strings.Cut(id, "#"); id == InstanceActor.ID() {
+		return InstanceActor
+	}
+	return nil
+}
+
+func (a *LocalActor) Path() string {
+	if a.instance {
+		return "/ap/actor"
+	}
+	return "/ap/user/" + a.Username
+}
+
+func (a *LocalActor) ID() string {
+	return GetHostSite() + a.Path()
+}
+
+// SigningKey is the key everything the actor sends is signed with
+func (a *LocalActor) SigningKey() *config.Key {
+	return &a.Keys[0]
+}
+
+func (a *LocalActor) KeyID() string {
+	return a.ID() + "#" + a.SigningKey().ID
+}
+
+// PrivateKeyFile is where the scripts read the signing key from, relative to
+// the repository root. The keys from before rotation have their old names.
+func (a *LocalActor) PrivateKeyFile() string {
+	key := a.SigningKey()
+	switch {
+	case key.ID != "main-key":
+		return "private-" + a.Username + "-" + key.ID + ".pem"
+	case a == DefaultActor():
+		return "private.pem"
+	default:
+		return "private-" + a.Username + ".pem"
+	}
+}
+
+// The signing key, followed by any rotated out keys still in their overlap
+// period
+func (a *LocalActor) liveKeys() []config.Key {
+	var keys []config.Key
+	for i, key := range a.Keys {
+		if i > 0 && time.Now().After(key.RetireAfter) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (a *LocalActor) publishedKeys() []any {
+	var keys []any
+	for _, key := range a.liveKeys() {
+		keys = append(keys, map[string]any{
+			"id":           a.ID() + "#" + key.ID,
+			"owner":        a.ID(),
+			"publicKeyPem": key.PublicKeyPEM,
+		})
+	}
+	return keys
+}
+
+// The actor as it would be fetched by RecvActivity
+func (a *LocalActor) asActor() *Actor {
+	return &Actor{
+		Id:                a.ID(),
+		Name:              a.Name,
+		PreferredUsername: a.Username,
+		Inbox:             a.InboxID(),
+	}
+}
+
+func (a *LocalActor) InboxID() string {
+	if a.instance {
+		// anything sent to it can go with everything else
+		return GetHostSite() + "/ap/inbox"
+	}
+	return a.collectionID("inbox")
+}
+
+func (a *LocalActor) OutboxID() string {
+	return a.collectionID("outbox")
+}
+
+func (a *LocalActor) FollowersID() string {
+	return a.collectionID("followers")
+}
+
+// The default actor keeps the site-wide IDs its collections had from before
+// there was more than one actor, since its followers' servers have them cached
+// and address its posts by them. /ap/user/<name>/... is routed for it too.
+func (a *LocalActor) collectionID(name string) string {
+	if a == DefaultActor() {
+		return GetHostSite() + "/ap/" + name
+	}
+	return a.ID() + "/" + name
+}
+
+// The firestore collection the actor's followers are kept in. The default
+// actor's are in "followers", from before there was more than one.
+func (a *LocalActor) FollowersCollection() string {
+	if a == DefaultActor() {
+		return "followers"
+	}
+	return "followers-" + a.Username
+}
+
+// Document is the actor object served at its ID
+func (a *LocalActor) Document() map[string]any {
+	host := GetHostSite()
+	if a.instance {
+		return a.instanceDocument(host)
+	}
+	doc := map[string]any{
+		"@context": []any{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+			map[string]any{
+				"toot":          "http://joinmastodon.org/ns#",
+				"discoverable":  "toot:discoverable",
+				"indexable":     "toot:indexable",
+				"memorial":      "toot:memorial",
+				"schema":        "http://schema.org#",
+				"PropertyValue": "schema:PropertyValue",
+				"value":         "schema:value",
+				"alsoKnownAs": map[string]any{
+					"@id":   "as:alsoKnownAs",
+					"@type": "@id",
+				},
+				"movedTo": map[string]any{
+					"@id":   "as:movedTo",
+					"@type": "@id",
+				},
+			},
+		},
+		"id":        a.ID(),
+		"type":      "Person",
+		"inbox":     a.InboxID(),
+		"outbox":    a.OutboxID(),
+		"followers": a.FollowersID(),
+		"endpoints": map[string]any{
+			"sharedInbox": host + "/ap/inbox",
+		},
+		"preferredUsername": a.Username,
+		"name":              a.Name,
+		"summary":           a.Summary,
+		"url":               host + a.ProfilePage,
+		"discoverable":      true,
+		"indexable":         true,
+		"memorial":          false,
+		"attachment": []any{
+			map[string]any{
+				"type":  "PropertyValue",
+				"name":  "Blog",
+				"value": `<a href="` + host + `" target="_blank">` + strings.TrimPrefix(host, "https://") + `</a>`,
+			},
+		},
+	}
+	doc["publicKey"] = a.publicKeyProperty()
+	if len(a.AlsoKnownAs) > 0 {
+		doc["alsoKnownAs"] = a.AlsoKnownAs
+	}
+	if a.MovedTo != "" {
+		doc["movedTo"] = a.MovedTo
+	}
+	if a.Icon != "" {
+		doc["icon"] = imageObject(host, a.Icon)
+	}
+	if a.Image != "" {
+		doc["image"] = imageObject(host, a.Image)
+	}
+	return doc
+}
+
+// Only publishes an array while a rotated out key is still in its overlap
+// period, since most servers only understand one key. Mastodon keeps just the
+// first, which is the one we sign with, so the old key is only there for
+// servers that look up whichever key the keyId names.
+func (a *LocalActor) publicKeyProperty() any {
+	keys := a.publishedKeys()
+	if len(keys) > 1 {
+		return keys
+	}
+	return keys[0]
+}
+
+func (a *LocalActor) instanceDocument(host string) map[string]any {
+	return map[string]any{
+		"@context": []any{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+		},
+		"id":    a.ID(),
+		"type":  "Application",
+		"inbox": a.InboxID(),
+		"endpoints": map[string]any{
+			"sharedInbox": host + "/ap/inbox",
+		},
+		"preferredUsername":         a.Username,
+		"name":                      a.Name,
+		"summary":                   a.Summary,
+		"url":                       host + a.ProfilePage,
+		"manuallyApprovesFollowers": true,
+		"publicKey":                 a.publicKeyProperty(),
+	}
+}
+
+func imageObject(host, src string) map[string]any {
+	if strings.HasPrefix(src, "/") {
+		src = host + src
+	}
+	image := map[string]any{"type": "Image", "url": src}
+	if mediaType := mime.TypeByExtension(path.Ext(src)); mediaType != "" {
+		image["mediaType"] = mediaType
+	}
+	return image
+}
@@ -0,0 +1,31 @@
+package ap
+
+import (
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/config"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func TestCollectionIDs(t *testing.T) {
+	host := GetHostSite()
+	other := &LocalActor{Actor: config.Actor{Username: "other"}}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"default inbox", DefaultActor().InboxID(), host + "/ap/inbox"},
+		{"default outbox", DefaultActor().OutboxID(), host + "/ap/outbox"},
+		{"default followers", DefaultActor().FollowersID(), host + "/ap/followers"},
+		{"other inbox", other.InboxID(), host + "/ap/user/other/inbox"},
+		{"other outbox", other.OutboxID(), host + "/ap/user/other/outbox"},
+		{"other followers", other.FollowersID(), host + "/ap/user/other/followers"},
+		{"instance inbox", InstanceActor.InboxID(), host + "/ap/inbox"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s is %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
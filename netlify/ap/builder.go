@@ -0,0 +1,170 @@
+package ap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// Activity is an outgoing activity sent by our actor. Build one with the New*
+// functions below and marshal it with Payload, rather than splicing strings
+// into a JSON template, so that names and bodies containing quotes survive.
+type Activity struct {
+	Context   any      `json:"@context,omitempty"`
+	Id        string   `json:"id"`
+	Type      string   `json:"type"`
+	Actor     string   `json:"actor"`
+	Object    any      `json:"object,omitempty"`
+	Target    any      `json:"target,omitempty"`
+	To        []string `json:"to,omitempty"`
+	Cc        []string `json:"cc,omitempty"`
+	Published string   `json:"published,omitempty"`
+}
+
+func newActivity(typ, actorID string) *Activity {
+	return &Activity{
+		Context:   "https://www.w3.org/ns/activitystreams",
+		Id:        newActivityID(typ),
+		Type:      typ,
+		Actor:     actorID,
+		Published: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func newActivityID(typ string) string {
+	buff := make([]byte, 16)
+	rand.Read(buff)
+	return fmt.Sprintf("%s/ap/activities/%s-%s", GetHostSite(),
+		strings.ToLower(typ), hex.EncodeToString(buff))
+}
+
+// NewAccept accepts a follow request, given either as the embedded Follow
+// activity or its ID
+func NewAccept(from *LocalActor, follow any, follower *Actor) *Activity {
+	a := newActivity("Accept", from.ID())
+	a.Object = follow
+	a.To = []string{follower.Id}
+	return a
+}
+
+func NewReject(from *LocalActor, follow any, follower *Actor) *Activity {
+	a := newActivity("Reject", from.ID())
+	a.Object = follow
+	a.To = []string{follower.Id}
+	return a
+}
+
+func NewFollow(from *LocalActor, target *Actor) *Activity {
+	a := newActivity("Follow", from.ID())
+	a.Object = target.Id
+	a.To = []string{target.Id}
+	return a
+}
+
+// NewCreate wraps an object we authored. The activity takes the object's
+// addressing; objects without any are made public and sent to our followers.
+func NewCreate(from *LocalActor, object map[string]any) *Activity {
+	a := newActivity("Create", from.ID())
+	addressObject(a, object, from)
+	if published, ok := object["published"].(string); ok {
+		a.Published = published
+	} else {
+		object["published"] = a.Published
+	}
+	return a
+}
+
+func NewUpdate(from *LocalActor, object map[string]any) *Activity {
+	a := newActivity("Update", from.ID())
+	addressObject(a, object, from)
+	if _, ok := object["updated"]; !ok && !isActorType(object["type"]) {
+		object["updated"] = a.Published
+	}
+	return a
+}
+
+// NewMove tells the actor's followers it now lives at target, which must
+// list the actor in its alsoKnownAs
+func NewMove(from *LocalActor, target string) *Activity {
+	a := newActivity("Move", from.ID())
+	a.Object = from.ID()
+	a.Target = target
+	a.To = []string{from.FollowersID()}
+	return a
+}
+
+// NewUndo reverses one of our previous activities and goes to the same
+// audience
+func NewUndo(activity *Activity) *Activity {
+	a := newActivity("Undo", activity.Actor)
+	undone := *activity
+	undone.Context = nil
+	a.Object = &undone
+	a.To = activity.To
+	a.Cc = activity.Cc
+	return a
+}
+
+func addressObject(a *Activity, object map[string]any, from *LocalActor) {
+	isActor := isActorType(object["type"])
+	if _, ok := object["attributedTo"]; !ok && !isActor {
+		object["attributedTo"] = a.Actor
+	}
+	_, hasTo := object["to"]
+	_, hasCc := object["cc"]
+	if hasTo || hasCc {
+		a.To = ToStringSlice(object["to"])
+		a.Cc = ToStringSlice(object["cc"])
+	} else {
+		a.To = []string{PublicAddress}
+		a.Cc = []string{from.FollowersID()}
+		if !isActor {
+			object["to"] = a.To
+			object["cc"] = a.Cc
+		}
+	}
+	// the activity carries the context, so it isn't needed on the object
+	delete(object, "@context")
+	a.Object = object
+}
+
+func isActorType(typ any) bool {
+	switch typ {
+	case "Person", "Application", "Service", "Group", "Organization":
+		return true
+	}
+	return false
+}
+
+// ToStringSlice reads a property that may be a single string or an array
+func ToStringSlice(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var strs []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// Payload encodes the activity for SendActivity
+func (a *Activity) Payload() (string, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("could not encode %s activity: %w", a.Type, err)
+	}
+	return string(payload), nil
+}
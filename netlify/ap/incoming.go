@@ -11,62 +11,122 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"reflect"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/maxbanister/blog/netlify/config"
 	. "github.com/maxbanister/blog/netlify/util"
 )
 
 func RecvActivity(r *LambdaRequest, requestJSON map[string]any) (*Actor, error) {
-	reqDate, err := time.Parse(http.TimeFormat, r.Headers["date"])
+	err := checkDigest(r)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
 	}
-	if time.Since(reqDate) >= 2*time.Hour {
-		return nil, fmt.Errorf("%w: date header too old", ErrBadRequest)
+
+	// fetch actor object
+	actorProperty, exists := requestJSON["actor"]
+	if !exists {
+		return nil, fmt.Errorf("%w: no actor found", ErrBadRequest)
 	}
+	return verifySignature(r, r.Path, actorProperty)
+}
 
-	err = checkDigest(r)
+// VerifyFetch checks the signature on a GET for one of our objects or
+// collections, returning who signed it. A GET has no body, so there's no
+// digest to check. addedParams are the query parameters the redirect to the
+// function adds, which the signer never saw.
+func VerifyFetch(r *LambdaRequest, addedParams ...string) (*Actor, error) {
+	query := url.Values{}
+	for param, value := range r.QueryStringParameters {
+		if !slices.Contains(addedParams, param) {
+			query.Set(param, value)
+		}
+	}
+	target := r.Path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return verifySignature(r, target, nil)
+}
+
+// VerifyCollectionFetch guards collections the site's own pages also read.
+// Signed requests are checked whether or not authorized fetch is on, so
+// blocked servers are always turned away. With it on, unsigned requests may
+// only have the plain JSON the pages render, which shows no more than the
+// pages do; the ActivityPub collection must be signed for. Headers like Origin
+// can't tell our pages apart, as any client can send them.
+func VerifyCollectionFetch(r *LambdaRequest, wantsAP bool, addedParams ...string) error {
+	if r.Headers["signature"] != "" {
+		_, err := VerifyFetch(r, addedParams...)
+		return err
+	}
+	if config.Site.Features.AuthorizedFetch && wantsAP {
+		return fmt.Errorf("%w: fetch must be signed", ErrUnauthorized)
+	}
+	return nil
+}
+
+// Checks the request is signed by actorData, or when that's nil by whoever
+// owns the signature's key, and that they aren't blocked
+func verifySignature(r *LambdaRequest, target string, actorData any) (*Actor, error) {
+	reqDate, err := time.Parse(http.TimeFormat, r.Headers["date"])
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
 	}
+	if time.Since(reqDate) >= 2*time.Hour {
+		return nil, fmt.Errorf("%w: date header too old", ErrBadRequest)
+	}
 
 	sigBytes, keyID, sigStrHdrs, err := getSigHeaderParts(r)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
 	}
+	if r.HTTPMethod == "GET" && !strings.Contains(sigStrHdrs, "(request-target)") {
+		// otherwise the signature would do for any of our URLs
+		return nil, fmt.Errorf("%w: request target not signed", ErrBadRequest)
+	}
+	keyURL, _, _ := strings.Cut(keyID, "#")
+	keyURI, err := url.Parse(keyURL)
+	if err != nil || keyURI.Host == "" {
+		return nil, fmt.Errorf("%w: malformed key ID", ErrBadRequest)
+	}
+	if config.Site.IsBlocked(keyURI.Host) {
+		return nil, fmt.Errorf("%w: %s is blocked", ErrForbidden, keyURI.Host)
+	}
 
-	// fetch actor object
-	actorProperty, exists := requestJSON["actor"]
-	if !exists {
-		return nil, fmt.Errorf("%w: no actor found", ErrBadRequest)
+	if actorData == nil {
+		actorData = keyURL
 	}
-	if actorURL, isURL := actorProperty.(string); isURL {
-		keyURL, _, _ := strings.Cut(keyID, "#")
+	if actorURL, isURL := actorData.(string); isURL {
 		if keyURL != actorURL {
 			return nil, fmt.Errorf("%w: actor does not match key in signature",
 				ErrBadRequest)
 		}
 	}
-	actor, err := FetchActorAuthorized(requestJSON["actor"])
+	actor, publicKeyPEM, err := fetchSigner(actorData, keyID)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
 	}
-	rsaPublicKey, err := getActorPubKey(actor)
+	actorURI, _ := url.Parse(actor.Id)
+	if actorURI == nil || config.Site.IsBlocked(actorURI.Host) {
+		return nil, fmt.Errorf("%w: actor %s is blocked", ErrForbidden, actor.Id)
+	}
+	rsaPublicKey, err := parsePublicKey(publicKeyPEM)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
 	}
-	// erase the public key so we don't accidentally bloat our stored objects
-	actor.PublicKey = nil
 
-	h, m, p := r.Headers["host"], r.HTTPMethod, r.Path
-	signingString := getSigningString(h, m, p, sigStrHdrs, r.Headers)
+	h, m := r.Headers["host"], r.HTTPMethod
+	signingString := getSigningString(h, m, target, sigStrHdrs, r.Headers)
 
 	hashed := sha256.Sum256([]byte(signingString))
-	fmt.Println("signing string:", signingString)
+	slog.Debug("verifying signature", "signing_string", signingString)
 
 	err = rsa.VerifyPKCS1v15(rsaPublicKey, crypto.SHA256, hashed[:], sigBytes)
 	if err != nil {
@@ -77,6 +137,33 @@ func RecvActivity(r *LambdaRequest, requestJSON map[string]any) (*Actor, error)
 	return actor, nil
 }
 
+// Finds the signer and the public key to check its signature with. Our own
+// actors' keys are known without asking, which would otherwise mean a signed
+// request to ourselves that needs verifying in turn.
+func fetchSigner(actorData any, keyID string) (*Actor, string, error) {
+	if actorID, ok := actorData.(string); ok {
+		if local := keyOwner(actorID); local != nil {
+			_, keyName, _ := strings.Cut(keyID, "#")
+			// retired keys are no longer published, so no longer verify
+			for _, key := range local.liveKeys() {
+				if key.ID == keyName {
+					return local.asActor(), key.PublicKeyPEM, nil
+				}
+			}
+			return nil, "", fmt.Errorf("no key %s", keyID)
+		}
+	}
+
+	actor, err := fetchActor(actorData, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	// erase the public key so we don't accidentally bloat our stored objects
+	publicKeyPEM := actor.PublicKey.PublicKeyPEM
+	actor.PublicKey = nil
+	return actor, publicKeyPEM, nil
+}
+
 func getSigHeaderParts(r *LambdaRequest) ([]byte, string, string, error) {
 	signatureHeader := r.Headers["signature"]
 	if signatureHeader == "" {
@@ -103,7 +190,7 @@ func getSigHeaderParts(r *LambdaRequest) ([]byte, string, string, error) {
 		case "headers":
 			// headers are always lowercase in signature
 			// check if the headers values of each are equal
-			supportedHdrs := strings.Split(SupportedSigHeaders, " ")
+			supportedHdrs := strings.Split(verifiableSigHeaders, " ")
 			for _, hdrStr := range strings.Split(sigVal, " ") {
 				if !slices.Contains(supportedHdrs, hdrStr) {
 					return nil, "", "", errors.New("bad signature headers")
@@ -123,12 +210,7 @@ func getSigHeaderParts(r *LambdaRequest) ([]byte, string, string, error) {
 	return sigBytes, keyID, sigStrHdrs, nil
 }
 
-func getActorPubKey(actor *Actor) (*rsa.PublicKey, error) {
-	var publicKeyPEM string
-	if actor.PublicKey != nil {
-		publicKeyPEM = actor.PublicKey.PublicKeyPEM
-	}
-
+func parsePublicKey(publicKeyPEM string) (*rsa.PublicKey, error) {
 	publicBlock, _ := pem.Decode([]byte(publicKeyPEM))
 	if publicBlock == nil || publicBlock.Type != "PUBLIC KEY" {
 		return nil, errors.New("failed to decode public key")
@@ -147,6 +229,12 @@ func getActorPubKey(actor *Actor) (*rsa.PublicKey, error) {
 }
 
 func FetchActorAuthorized(actorData any) (*Actor, error) {
+	return fetchActor(actorData, "")
+}
+
+// Fetches an actor keeping the public key keyID names, or the first if it
+// publishes several and keyID is empty
+func fetchActor(actorData any, keyID string) (*Actor, error) {
 	var respBody []byte
 
 	switch actorVal := actorData.(type) {
@@ -166,8 +254,17 @@ func FetchActorAuthorized(actorData any) (*Actor, error) {
 		return nil, fmt.Errorf("%w: unknown actor type", ErrBadRequest)
 	}
 
+	actorJSON, err := CompactJSON(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("bad json syntax: %s", err.Error())
+	}
+	if keys, ok := actorJSON["publicKey"].([]any); ok {
+		actorJSON["publicKey"] = pickKey(keys, keyID)
+	}
+	respBody, _ = json.Marshal(actorJSON)
+
 	var actor Actor
-	err := json.Unmarshal(respBody, &actor)
+	err = json.Unmarshal(respBody, &actor)
 	if err != nil {
 		return nil, fmt.Errorf("bad json syntax: %s", err.Error())
 	}
@@ -187,6 +284,19 @@ func FetchActorAuthorized(actorData any) (*Actor, error) {
 	return &actor, nil
 }
 
+// Actors rotating their keys can publish several, signing key first
+func pickKey(keys []any, keyID string) any {
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if keyMap, ok := key.(map[string]any); ok && keyMap["id"] == keyID {
+			return key
+		}
+	}
+	return keys[0]
+}
+
 func checkDigest(r *LambdaRequest) error {
 	digest := r.Headers["digest"]
 	if digest == "" {
@@ -0,0 +1,64 @@
+package ap
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/maxbanister/blog/netlify/config"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func TestVerifyCollectionFetch(t *testing.T) {
+	site := *config.Site
+	t.Cleanup(func() { *config.Site = site })
+	config.Site.BlockedDomains = []string{"blocked.example"}
+
+	blockedSig := `keyId="https://blocked.example/users/a#main-key",` +
+		`algorithm="rsa-sha256",headers="(request-target) host date",` +
+		`signature="c2ln"`
+	const apType, pageType = "application/activity+json", "application/json"
+	tests := []struct {
+		name            string
+		authorizedFetch bool
+		headers         map[string]string
+		want            error
+	}{
+		{"unsigned without authorized fetch", false,
+			map[string]string{"accept": apType}, nil},
+		{"unsigned from a server", true, map[string]string{"accept": apType}, ErrUnauthorized},
+		{"unsigned from our pages", true, map[string]string{"accept": pageType}, nil},
+		{"unsigned with forged fetch site", true, map[string]string{
+			"accept":         apType,
+			"sec-fetch-site": "same-origin",
+		}, ErrUnauthorized},
+		{"unsigned with forged origin", true, map[string]string{
+			"accept": apType,
+			"origin": config.Site.BaseURL,
+		}, ErrUnauthorized},
+		{"blocked without authorized fetch", false,
+			map[string]string{"accept": apType, "signature": blockedSig}, ErrForbidden},
+		{"blocked asking for the page's json", true,
+			map[string]string{"accept": pageType, "signature": blockedSig}, ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Site.Features.AuthorizedFetch = tt.authorizedFetch
+			tt.headers["date"] = time.Now().UTC().Format(http.TimeFormat)
+			r := &LambdaRequest{
+				HTTPMethod: "GET",
+				Path:       "/posts/hello/replies",
+				Headers:    tt.headers,
+			}
+			wantsAP := tt.headers["accept"] == apType
+			err := VerifyCollectionFetch(r, wantsAP)
+			if tt.want == nil && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
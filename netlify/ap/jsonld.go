@@ -0,0 +1,341 @@
+package ap
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Offline copies of the contexts remote servers commonly reference. We never
+// fetch a context over the network, so unknown context URLs are ignored and
+// their terms are left untouched.
+//
+//go:embed contexts/*.jsonld
+var contextFiles embed.FS
+
+const asNamespace = "https://www.w3.org/ns/activitystreams#"
+const PublicAddress = asNamespace + "Public"
+
+var bundledContexts = map[string]string{
+	"https://www.w3.org/ns/activitystreams":         "activitystreams.jsonld",
+	"https://www.w3.org/ns/activitystreams.jsonld":  "activitystreams.jsonld",
+	"https://w3id.org/security/v1":                  "security-v1.jsonld",
+	"https://litepub.social/litepub/context.jsonld": "litepub-0.1.jsonld",
+}
+
+// The context outgoing and normalized documents are expressed in
+var CanonicalContext = []any{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+type termDef struct {
+	iri       string
+	container string
+}
+
+type ldContext struct {
+	vocab string
+	terms map[string]termDef
+}
+
+var canonical struct {
+	once    sync.Once
+	reverse map[string]string // expanded IRI + "|" + container -> term
+}
+
+func loadCanonical() {
+	ctx := &ldContext{terms: map[string]termDef{}}
+	ctx.extend("https://www.w3.org/ns/activitystreams", 0)
+	ctx.extend("https://w3id.org/security/v1", 0)
+	ctx.extend(readBundledContext("extensions.jsonld"), 0)
+
+	reverse := make(map[string]string)
+	for term, def := range ctx.terms {
+		iri := ctx.expand(def.iri, 0)
+		if iri == "" || strings.HasPrefix(iri, "@") || !isTermName(term) {
+			continue
+		}
+		// prefixes like "as" or "toot" aren't terms in their own right
+		if strings.HasSuffix(iri, "#") || strings.HasSuffix(iri, "/") {
+			continue
+		}
+		key := iri + "|" + def.container
+		// AS defines expires/expiration twice; stay deterministic
+		if existing, ok := reverse[key]; !ok || term < existing {
+			reverse[key] = term
+		}
+	}
+	canonical.reverse = reverse
+}
+
+func isTermName(term string) bool {
+	return term != "" && !strings.ContainsAny(term, ":@")
+}
+
+func readBundledContext(name string) any {
+	data, err := contextFiles.ReadFile(path.Join("contexts", name))
+	if err != nil {
+		panic(fmt.Sprintf("bundled context %s missing: %s", name, err))
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		panic(fmt.Sprintf("bundled context %s malformed: %s", name, err))
+	}
+	return doc["@context"]
+}
+
+func lookupBundledContext(url string) (any, bool) {
+	url = strings.TrimRight(url, "/#")
+	url = strings.Replace(url, "http://", "https://", 1)
+	if name, ok := bundledContexts[url]; ok {
+		return readBundledContext(name), true
+	}
+	// Pleroma and Akkoma serve litepub from the instance's own domain
+	if strings.HasSuffix(url, "/schemas/litepub-0.1.jsonld") {
+		return readBundledContext("litepub-0.1.jsonld"), true
+	}
+	return nil, false
+}
+
+func (c *ldContext) clone() *ldContext {
+	terms := make(map[string]termDef, len(c.terms))
+	for k, v := range c.terms {
+		terms[k] = v
+	}
+	return &ldContext{vocab: c.vocab, terms: terms}
+}
+
+// Merges a @context value (URL, inline object, or an array of either) into
+// the active context
+func (c *ldContext) extend(value any, depth int) {
+	if depth > 8 {
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		if bundled, ok := lookupBundledContext(v); ok {
+			c.extend(bundled, depth+1)
+		}
+	case []any:
+		for _, item := range v {
+			c.extend(item, depth+1)
+		}
+	case map[string]any:
+		for term, def := range v {
+			switch term {
+			case "@vocab":
+				c.vocab, _ = def.(string)
+				continue
+			case "@language", "@version", "@protected", "@base":
+				continue
+			}
+			switch d := def.(type) {
+			case nil:
+				delete(c.terms, term)
+			case string:
+				c.terms[term] = termDef{iri: d}
+			case map[string]any:
+				iri, _ := d["@id"].(string)
+				if iri == "" {
+					iri = term
+				}
+				container, _ := d["@container"].(string)
+				c.terms[term] = termDef{iri: iri, container: container}
+			}
+		}
+	}
+}
+
+// Expands a term, compact IRI ("as:Note"), or absolute IRI to an absolute IRI.
+// Returns "" if the context doesn't define it.
+func (c *ldContext) expand(value string, depth int) string {
+	if depth > 8 || value == "" {
+		return ""
+	}
+	if strings.HasPrefix(value, "@") {
+		return value
+	}
+	if prefix, suffix, found := strings.Cut(value, ":"); found {
+		if def, ok := c.terms[prefix]; ok && !strings.HasPrefix(suffix, "//") {
+			base := c.expand(def.iri, depth+1)
+			if base == "" {
+				return ""
+			}
+			return normalizeIRI(base + suffix)
+		}
+		return normalizeIRI(value)
+	}
+	if def, ok := c.terms[value]; ok {
+		if def.iri == value {
+			// term defined only by its container, fall through to @vocab
+			return c.expandVocab(value)
+		}
+		return c.expand(def.iri, depth+1)
+	}
+	return c.expandVocab(value)
+}
+
+func (c *ldContext) expandVocab(value string) string {
+	if c.vocab == "" || c.vocab == "_:" {
+		return ""
+	}
+	return normalizeIRI(c.vocab + value)
+}
+
+// Different servers spell the same namespaces slightly differently
+func normalizeIRI(iri string) string {
+	switch {
+	case strings.HasPrefix(iri, "http://www.w3.org/ns/activitystreams#"):
+		return "https" + strings.TrimPrefix(iri, "http")
+	case strings.HasPrefix(iri, "https://schema.org/"):
+		return "http://schema.org#" + strings.TrimPrefix(iri, "https://schema.org/")
+	case strings.HasPrefix(iri, "http://schema.org/"):
+		return "http://schema.org#" + strings.TrimPrefix(iri, "http://schema.org/")
+	}
+	return iri
+}
+
+// Looks up the canonical term for a key found in a document with context c
+func (c *ldContext) compactKey(key string) string {
+	switch key {
+	case "@id":
+		return "id"
+	case "@type":
+		return "type"
+	}
+	iri := c.expand(key, 0)
+	switch iri {
+	case "":
+		// undefined by the document, so take it at face value
+		return key
+	case "@id":
+		return "id"
+	case "@type":
+		return "type"
+	}
+	if term, ok := canonical.reverse[iri+"|"+c.terms[key].container]; ok {
+		return term
+	}
+	if term, ok := canonical.reverse[iri+"|"]; ok {
+		return term
+	}
+	return key
+}
+
+// Looks up the canonical term for a type name or an IRI-valued string
+func (c *ldContext) compactIRI(value string) string {
+	iri := c.expand(value, 0)
+	if iri == "" {
+		return value
+	}
+	if term, ok := canonical.reverse[iri+"|"]; ok {
+		return term
+	}
+	return value
+}
+
+// Compact rewrites an incoming JSON-LD document so that its properties,
+// types and the Public address use the plain terms the rest of the code
+// expects, regardless of which prefixes or extension contexts the sender used.
+func Compact(doc map[string]any) map[string]any {
+	canonical.once.Do(loadCanonical)
+	ctx := &ldContext{terms: map[string]termDef{}}
+	compacted, _ := compactValue(doc, ctx).(map[string]any)
+	if compacted == nil {
+		return doc
+	}
+	compacted["@context"] = CanonicalContext
+	return compacted
+}
+
+// CompactJSON decodes a document and compacts it
+func CompactJSON(data []byte) (map[string]any, error) {
+	doc := make(map[string]any)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return Compact(doc), nil
+}
+
+func compactValue(value any, ctx *ldContext) any {
+	switch v := value.(type) {
+	case []any:
+		for i, item := range v {
+			v[i] = compactValue(item, ctx)
+		}
+		return v
+
+	case map[string]any:
+		if literal, ok := v["@value"]; ok {
+			return literal
+		}
+		if localCtx, ok := v["@context"]; ok {
+			ctx = ctx.clone()
+			ctx.extend(localCtx, 0)
+		}
+		out := make(map[string]any, len(v))
+		for key, val := range v {
+			if key == "@context" {
+				continue
+			}
+			term := ctx.compactKey(key)
+			switch term {
+			case "type":
+				val = compactTypes(val, ctx)
+			case "to", "cc", "bto", "bcc", "audience":
+				val = compactAddresses(val)
+			default:
+				val = compactValue(val, ctx)
+			}
+			// a canonical key wins over a prefixed duplicate of it
+			if _, exists := out[term]; exists && term != key {
+				continue
+			}
+			out[term] = val
+		}
+		return out
+	}
+	return value
+}
+
+func compactTypes(value any, ctx *ldContext) any {
+	switch v := value.(type) {
+	case string:
+		return ctx.compactIRI(v)
+	case []any:
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				v[i] = ctx.compactIRI(s)
+			}
+		}
+		// most handlers expect a single type name
+		if len(v) == 1 {
+			return v[0]
+		}
+		return v
+	}
+	return value
+}
+
+func compactAddresses(value any) any {
+	isPublic := func(s string) bool {
+		return s == "as:Public" || s == "Public" || normalizeIRI(s) == PublicAddress
+	}
+	switch v := value.(type) {
+	case string:
+		if isPublic(v) {
+			return PublicAddress
+		}
+	case []any:
+		for i, item := range v {
+			if s, ok := item.(string); ok && isPublic(s) {
+				v[i] = PublicAddress
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,212 @@
+package ap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompactJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]any
+	}{
+		{
+			name: "mastodon note",
+			in: `{
+				"@context": [
+					"https://www.w3.org/ns/activitystreams",
+					{
+						"ostatus": "http://ostatus.org#",
+						"atomUri": "ostatus:atomUri",
+						"sensitive": "as:sensitive",
+						"toot": "http://joinmastodon.org/ns#",
+						"votersCount": "toot:votersCount",
+						"blurhash": "toot:blurhash"
+					}
+				],
+				"id": "https://mastodon.example/users/alice/statuses/1/activity",
+				"type": "Create",
+				"actor": "https://mastodon.example/users/alice",
+				"to": ["https://www.w3.org/ns/activitystreams#Public"],
+				"cc": ["https://mastodon.example/users/alice/followers"],
+				"object": {
+					"id": "https://mastodon.example/users/alice/statuses/1",
+					"type": "Note",
+					"inReplyTo": "https://blog.example/posts/hello",
+					"sensitive": false,
+					"atomUri": "https://mastodon.example/users/alice/statuses/1",
+					"content": "<p>hi</p>",
+					"contentMap": {"en": "<p>hi</p>"},
+					"attachment": [{
+						"type": "Document",
+						"mediaType": "image/png",
+						"url": "https://mastodon.example/media/1.png",
+						"blurhash": "UBL_:rOpGG-oBUNG,qRj2so|=eE1w^n4S5NH"
+					}]
+				}
+			}`,
+			want: map[string]any{
+				"@context": CanonicalContext,
+				"id":       "https://mastodon.example/users/alice/statuses/1/activity",
+				"type":     "Create",
+				"actor":    "https://mastodon.example/users/alice",
+				"to":       []any{PublicAddress},
+				"cc":       []any{"https://mastodon.example/users/alice/followers"},
+				"object": map[string]any{
+					"id":         "https://mastodon.example/users/alice/statuses/1",
+					"type":       "Note",
+					"inReplyTo":  "https://blog.example/posts/hello",
+					"sensitive":  false,
+					"atomUri":    "https://mastodon.example/users/alice/statuses/1",
+					"content":    "<p>hi</p>",
+					"contentMap": map[string]any{"en": "<p>hi</p>"},
+					"attachment": []any{map[string]any{
+						"type":      "Document",
+						"mediaType": "image/png",
+						"url":       "https://mastodon.example/media/1.png",
+						"blurhash":  "UBL_:rOpGG-oBUNG,qRj2so|=eE1w^n4S5NH",
+					}},
+				},
+			},
+		},
+		{
+			name: "pleroma like with litepub context",
+			in: `{
+				"@context": [
+					"https://www.w3.org/ns/activitystreams",
+					"https://pleroma.example/schemas/litepub-0.1.jsonld",
+					{"@language": "und"}
+				],
+				"id": "https://pleroma.example/activities/2",
+				"type": "Like",
+				"actor": "https://pleroma.example/users/bob",
+				"object": "https://blog.example/posts/hello",
+				"to": ["https://blog.example/ap/user/max", "as:Public"],
+				"cc": "Public",
+				"context": "https://pleroma.example/contexts/3",
+				"directMessage": false
+			}`,
+			want: map[string]any{
+				"@context":      CanonicalContext,
+				"id":            "https://pleroma.example/activities/2",
+				"type":          "Like",
+				"actor":         "https://pleroma.example/users/bob",
+				"object":        "https://blog.example/posts/hello",
+				"to":            []any{"https://blog.example/ap/user/max", PublicAddress},
+				"cc":            PublicAddress,
+				"context":       "https://pleroma.example/contexts/3",
+				"directMessage": false,
+			},
+		},
+		{
+			name: "misskey note with prefixed terms",
+			in: `{
+				"@context": [
+					"https://www.w3.org/ns/activitystreams",
+					"https://w3id.org/security/v1",
+					{
+						"misskey": "https://misskey-hub.net/ns#",
+						"_misskey_content": "misskey:_misskey_content",
+						"_misskey_quote": "misskey:_misskey_quote",
+						"quoteUrl": "as:quoteUrl",
+						"Hashtag": "as:Hashtag",
+						"Emoji": "toot:Emoji",
+						"toot": "http://joinmastodon.org/ns#"
+					}
+				],
+				"id": "https://misskey.example/notes/4",
+				"type": "Note",
+				"attributedTo": "https://misskey.example/users/carol",
+				"as:inReplyTo": "https://blog.example/posts/hello",
+				"_misskey_content": "hi :wave:",
+				"content": "<p>hi :wave:</p>",
+				"to": ["https://www.w3.org/ns/activitystreams#Public"],
+				"tag": [
+					{"type": "Emoji", "name": ":wave:",
+						"icon": {"type": "Image", "url": "https://misskey.example/wave.png"}},
+					{"type": "Hashtag", "name": "#hello"}
+				]
+			}`,
+			want: map[string]any{
+				"@context":         CanonicalContext,
+				"id":               "https://misskey.example/notes/4",
+				"type":             "Note",
+				"attributedTo":     "https://misskey.example/users/carol",
+				"inReplyTo":        "https://blog.example/posts/hello",
+				"_misskey_content": "hi :wave:",
+				"content":          "<p>hi :wave:</p>",
+				"to":               []any{PublicAddress},
+				"tag": []any{
+					map[string]any{"type": "Emoji", "name": ":wave:",
+						"icon": map[string]any{"type": "Image",
+							"url": "https://misskey.example/wave.png"}},
+					map[string]any{"type": "Hashtag", "name": "#hello"},
+				},
+			},
+		},
+		{
+			name: "prefixed types and public address",
+			in: `{
+				"@context": {"as": "https://www.w3.org/ns/activitystreams#"},
+				"@id": "https://other.example/5",
+				"@type": "as:Announce",
+				"as:actor": {"@id": "https://other.example/users/dan"},
+				"as:object": "https://blog.example/posts/hello",
+				"as:to": "as:Public"
+			}`,
+			want: map[string]any{
+				"@context": CanonicalContext,
+				"id":       "https://other.example/5",
+				"type":     "Announce",
+				"actor":    map[string]any{"id": "https://other.example/users/dan"},
+				"object":   "https://blog.example/posts/hello",
+				"to":       PublicAddress,
+			},
+		},
+		{
+			name: "canonical key wins over prefixed duplicate",
+			in: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"type": "Note",
+				"content": "plain",
+				"as:content": "prefixed"
+			}`,
+			want: map[string]any{
+				"@context": CanonicalContext,
+				"type":     "Note",
+				"content":  "plain",
+			},
+		},
+		{
+			name: "unknown context leaves terms alone",
+			in: `{
+				"@context": "https://unknown.example/context.jsonld",
+				"type": "Note",
+				"custom:thing": "x"
+			}`,
+			want: map[string]any{
+				"@context":     CanonicalContext,
+				"type":         "Note",
+				"custom:thing": "x",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CompactJSON([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactJSONInvalid(t *testing.T) {
+	if _, err := CompactJSON([]byte(`{"type":`)); err == nil {
+		t.Error("no error for truncated JSON")
+	}
+}
@@ -1,7 +1,6 @@
 package ap
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,6 +11,8 @@ import (
 type FollowServiceRequest struct {
 	FollowObj string
 	Actor     []byte
+	// set when the follow should be rejected rather than accepted
+	Reject bool `json:",omitempty"`
 }
 
 type Actor struct {
@@ -28,21 +29,55 @@ type Actor struct {
 type InnerReplies struct {
 	Id    string `json:"id"`
 	Items []any  `json:"items"`
+	// set by reply-service when not every reply was loaded; TotalItems
+	// counts them all and Next continues where Items leaves off
+	TotalItems int    `json:"totalItems,omitempty" firestore:"-"`
+	Next       string `json:"next,omitempty" firestore:"-"`
 }
 
 type Reply struct {
-	Id           string       `json:"id"`
-	Type         string       `json:"type,omitempty" firestore:",omitempty"`
-	InReplyTo    any          `json:"inReplyTo,omitempty" firestore:",omitempty"`
-	Published    string       `json:"published,omitempty" firestore:",omitempty"`
-	Updated      string       `json:"updated,omitempty" firestore:",omitempty"`
-	URL          string       `json:"url,omitempty" firestore:",omitempty"`
-	AttributedTo string       `json:"attributedTo,omitempty" firestore:",omitempty"`
-	To           []string     `json:"to,omitempty" firestore:",omitempty"`
-	Cc           []string     `json:"cc,omitempty" firestore:",omitempty"`
-	Content      string       `json:"content,omitempty" firestore:",omitempty"`
-	Replies      InnerReplies `json:"replies"`
-	Actor        *Actor       `json:"actor,omitempty" firestore:",omitempty"`
+	Id           string   `json:"id"`
+	Type         string   `json:"type,omitempty" firestore:",omitempty"`
+	InReplyTo    any      `json:"inReplyTo,omitempty" firestore:",omitempty"`
+	Published    string   `json:"published,omitempty" firestore:",omitempty"`
+	Updated      string   `json:"updated,omitempty" firestore:",omitempty"`
+	URL          string   `json:"url,omitempty" firestore:",omitempty"`
+	AttributedTo string   `json:"attributedTo,omitempty" firestore:",omitempty"`
+	To           []string `json:"to,omitempty" firestore:",omitempty"`
+	Cc           []string `json:"cc,omitempty" firestore:",omitempty"`
+	Content      string   `json:"content,omitempty" firestore:",omitempty"`
+	// the content as the remote server sent it, kept for audit. Content is
+	// only ever stored and served sanitized.
+	RawContent string       `json:"-" firestore:",omitempty"`
+	Summary    string       `json:"summary,omitempty" firestore:",omitempty"`
+	Sensitive  bool         `json:"sensitive,omitempty" firestore:",omitempty"`
+	Attachment []Attachment `json:"attachment,omitempty" firestore:",omitempty"`
+	Tag        []Tag        `json:"tag,omitempty" firestore:",omitempty"`
+	// earlier versions of an edited reply, oldest first
+	History []Revision   `json:"history,omitempty" firestore:",omitempty"`
+	Replies InnerReplies `json:"replies"`
+	Actor   *Actor       `json:"actor,omitempty" firestore:",omitempty"`
+	// the post the thread hangs from, set when the reply is stored so which
+	// post a reply is under takes one read. Replies stored before it was
+	// kept don't have it.
+	Root string `json:"-" firestore:",omitempty"`
+	// how the reply arrived, ViaWebmention or empty for ActivityPub
+	Via string `json:"-" firestore:",omitempty"`
+}
+
+// Marks what arrived as a webmention. Anyone can send one naming any source,
+// so they may only replace or remove what came the same way.
+const ViaWebmention = "webmention"
+
+// Revision is a reply as it was before an edit. Updated is when that
+// version was written.
+type Revision struct {
+	Updated    string       `json:"updated"`
+	Content    string       `json:"content"`
+	RawContent string       `json:"-" firestore:",omitempty"`
+	Summary    string       `json:"summary,omitempty" firestore:",omitempty"`
+	Sensitive  bool         `json:"sensitive,omitempty" firestore:",omitempty"`
+	Attachment []Attachment `json:"attachment,omitempty" firestore:",omitempty"`
 }
 
 type LikeOrShare struct {
@@ -50,6 +85,8 @@ type LikeOrShare struct {
 	URL    string `json:"url"`
 	Object string `json:"object"`
 	Actor  *Actor `json:"actor"`
+	// how it arrived, ViaWebmention or empty for ActivityPub
+	Via string `json:"-" firestore:",omitempty"`
 }
 
 type LikeOrShareContainer struct {
@@ -60,6 +97,9 @@ type LikeOrShareContainer struct {
 const SupportedSigHeaders = "host date digest content-type (request-target)"
 const FetchSigHeaders = "host date digest (request-target)"
 
+// Mastodon also signs the accept header of its fetches
+const verifiableSigHeaders = SupportedSigHeaders + " accept"
+
 func getSigningString(host, method, path, sigHeaders string, hdrs any) string {
 	var outStr strings.Builder
 	hdrList := strings.Split(sigHeaders, " ")
@@ -67,7 +107,7 @@ func getSigningString(host, method, path, sigHeaders string, hdrs any) string {
 		switch hdr {
 		case "host":
 			outStr.WriteString(hdr + ": " + host)
-		case "date", "digest", "content-type":
+		case "date", "digest", "content-type", "accept":
 			// could be from a gostd http request or lambda request
 			if sliceHdr, ok := hdrs.(http.Header); ok {
 				outStr.WriteString(hdr + ": " + strings.Join(sliceHdr[hdr], ""))
@@ -110,7 +150,7 @@ func GetObject(value any) (map[string]any, error) {
 		return nil, fmt.Errorf("could not fetch object: %w", err)
 	}
 
-	err = json.Unmarshal(respBody, &object)
+	object, err = CompactJSON(respBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal object body: %w", err)
 	}
@@ -0,0 +1,144 @@
+package ap
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mastodon and Threads cut posts off at 500 characters, and count every link
+// as 23 of them
+const NoteCharLimit = 500
+const linkCharCount = 23
+
+// The context for our posts, which adds the Mastodon extension for image
+// placeholders to ActivityStreams
+var ObjectContext = []any{
+	"https://www.w3.org/ns/activitystreams",
+	map[string]any{
+		"toot":     "http://joinmastodon.org/ns#",
+		"blurhash": "toot:blurhash",
+	},
+}
+
+// Object is a post we publish, either as a short Note or a full Article, or a
+// reply to one that we serve a copy of
+type Object struct {
+	Context      any               `json:"@context,omitempty"`
+	Id           string            `json:"id"`
+	Type         string            `json:"type"`
+	Name         string            `json:"name,omitempty"`
+	Summary      string            `json:"summary,omitempty"`
+	Sensitive    bool              `json:"sensitive,omitempty"`
+	Content      string            `json:"content,omitempty"`
+	ContentMap   map[string]string `json:"contentMap,omitempty"`
+	MediaType    string            `json:"mediaType,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	AttributedTo string            `json:"attributedTo,omitempty"`
+	InReplyTo    string            `json:"inReplyTo,omitempty"`
+	To           []string          `json:"to,omitempty"`
+	Cc           []string          `json:"cc,omitempty"`
+	Published    string            `json:"published,omitempty"`
+	Updated      string            `json:"updated,omitempty"`
+	Replies      any               `json:"replies,omitempty"`
+	Likes        string            `json:"likes,omitempty"`
+	Shares       string            `json:"shares,omitempty"`
+	Attachment   []Attachment      `json:"attachment,omitempty"`
+	Tag          []Tag             `json:"tag,omitempty"`
+}
+
+// Attachment is a media file on an object. For images, Name holds the alt
+// text and Blurhash a placeholder to show while it loads.
+type Attachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	URL       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Blurhash  string `json:"blurhash,omitempty"`
+}
+
+// Tag is a Hashtag, Mention or Emoji on an object. Emoji carry their image
+// as an icon, and are named by their shortcode, e.g. ":blobcat:".
+type Tag struct {
+	Type string      `json:"type"`
+	Href string      `json:"href,omitempty" firestore:",omitempty"`
+	Name string      `json:"name"`
+	Icon *Attachment `json:"icon,omitempty" firestore:",omitempty"`
+}
+
+// NotePreview condenses an Article for servers that don't render them: its
+// title, as much of the summary as fits within the character limit, a link to
+// the full post, and its hashtags. Attachments are kept so the hero image
+// still shows.
+func NotePreview(article *Object) *Object {
+	note := *article
+	note.Type = "Note"
+	note.Name = ""
+	note.Summary = ""
+	note.MediaType = ""
+
+	tagChars := 0
+	for _, tag := range article.Tag {
+		if tag.Type == "Hashtag" {
+			tagChars += utf8.RuneCountInString(tag.Name) + 1
+		}
+	}
+	// title, blank line, summary, blank line, link, blank line, tags
+	budget := NoteCharLimit - utf8.RuneCountInString(article.Name) -
+		linkCharCount - tagChars - 6
+	summary := truncateWords(html.UnescapeString(article.Summary), budget)
+
+	var content strings.Builder
+	content.WriteString("<p>" + html.EscapeString(article.Name) + "</p>")
+	if summary != "" {
+		content.WriteString("<p>" + html.EscapeString(summary) + "</p>")
+	}
+	content.WriteString(fmt.Sprintf(`<p><a href="%s">%s</a></p>`,
+		html.EscapeString(article.URL), html.EscapeString(article.URL)))
+	content.WriteString(HashtagLinks(article.Tag))
+	note.Content = content.String()
+
+	if len(article.ContentMap) > 0 {
+		note.ContentMap = make(map[string]string, len(article.ContentMap))
+		for lang := range article.ContentMap {
+			note.ContentMap[lang] = note.Content
+		}
+	}
+
+	return &note
+}
+
+// HashtagLinks renders the hashtags in tags the way Mastodon links them
+func HashtagLinks(tags []Tag) string {
+	var links []string
+	for _, tag := range tags {
+		if tag.Type != "Hashtag" {
+			continue
+		}
+		links = append(links, fmt.Sprintf(
+			`<a href="%s" class="mention hashtag" rel="tag">#<span>%s</span></a>`,
+			html.EscapeString(tag.Href),
+			html.EscapeString(strings.TrimPrefix(tag.Name, "#"))))
+	}
+	if len(links) == 0 {
+		return ""
+	}
+	return "<p>" + strings.Join(links, " ") + "</p>"
+}
+
+func truncateWords(text string, limit int) string {
+	if limit <= 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	cut := string([]rune(text)[:limit-1])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
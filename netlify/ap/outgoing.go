@@ -7,28 +7,44 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"reflect"
 	"strings"
 	"time"
-
-	. "github.com/maxbanister/blog/netlify/util"
 )
 
 func SendActivity(payload string, actor *Actor) error {
-	// post a message to actor inbox
-	_, err := RequestAuthorized("POST", payload, actor.Inbox)
+	// post a message to actor inbox, signed by whichever of ours sent it
+	_, err := RequestAuthorizedAs(signerFor(payload), "POST", payload, actor.Inbox)
 	return err
 }
 
+// Signs as the site rather than any of its actors; see SiteSigner
 func RequestAuthorized(method, payload, destURL string) ([]byte, error) {
-	//fmt.Println("Payload:", payload)
+	return RequestAuthorizedAs(SiteSigner(), method, payload, destURL)
+}
+
+// The local actor an activity is from; others can only verify a signature
+// made with the key of the activity's own actor
+func signerFor(payload string) *LocalActor {
+	var activity struct {
+		Actor string `json:"actor"`
+	}
+	json.Unmarshal([]byte(payload), &activity)
+	if signer := LocalActorByID(activity.Actor); signer != nil {
+		return signer
+	}
+	return DefaultActor()
+}
 
+func RequestAuthorizedAs(signer *LocalActor, method, payload, destURL string) ([]byte, error) {
 	r, err := http.NewRequest(method, destURL, strings.NewReader(payload))
 	if err != nil {
 		return nil, fmt.Errorf("couldn't post to actor inbox: %w", err)
@@ -54,9 +70,8 @@ func RequestAuthorized(method, payload, destURL string) ([]byte, error) {
 
 	h, m, p := r.Host, r.Method, r.URL.Path
 	signingString := getSigningString(h, m, p, sigHeaders, r.Header)
-	//fmt.Println("signing string 2:", signingString)
 
-	privKeyRSA, err := getPrivKey()
+	privKeyRSA, err := getPrivKey(signer.SigningKey().PrivateKeyEnv)
 	if err != nil {
 		return nil, err
 	}
@@ -72,13 +87,12 @@ func RequestAuthorized(method, payload, destURL string) ([]byte, error) {
 
 	r.Header["Signature"] = []string{
 		fmt.Sprintf(`keyId="%s",algorithm="%s",headers="%s",signature="%s"`,
-			GetHostSite()+"/ap/user/max#main-key",
+			signer.KeyID(),
 			"rsa-sha256",
 			sigHeaders,
 			sigBase64,
 		),
 	}
-	//fmt.Println("Signature:", r.Header["Signature"][0])
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
@@ -86,17 +100,19 @@ func RequestAuthorized(method, payload, destURL string) ([]byte, error) {
 	}
 	respBody, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Println(resp.StatusCode, string(respBody))
+		slog.Warn("request refused", "method", method, "url", destURL,
+			"status", resp.StatusCode, "body", string(respBody))
 		return nil, fmt.Errorf("http activity request error: %v", resp)
 	}
-	fmt.Println(resp.StatusCode, string(respBody))
+	slog.Debug("request accepted", "method", method, "url", destURL,
+		"status", resp.StatusCode)
 
 	return respBody, nil
 }
 
-func getPrivKey() (*rsa.PrivateKey, error) {
+func getPrivKey(envName string) (*rsa.PrivateKey, error) {
 	// read PKCIS private key
-	privKeyPEM := os.Getenv("AP_PRIVATE_KEY")
+	privKeyPEM := os.Getenv(envName)
 	privKeyPEM = strings.ReplaceAll(privKeyPEM, "\\n", "\n")
 
 	// Convert to PEM block
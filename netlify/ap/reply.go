@@ -0,0 +1,107 @@
+package ap
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// DecodeReply reads a reply object from an incoming Create or Update.
+// Servers disagree on whether attachment and tag are arrays, and on whether a
+// url is a string or Link objects, so those are normalized before decoding.
+func DecodeReply(object map[string]any) (*Reply, error) {
+	normalized := maps.Clone(object)
+	if _, ok := object["url"]; ok {
+		normalized["url"] = linkHref(object["url"])
+	}
+	// we keep track of replies to the reply ourselves
+	delete(normalized, "replies")
+
+	var attachments []any
+	for _, a := range asArray(object["attachment"]) {
+		if a, ok := a.(map[string]any); ok {
+			attachments = append(attachments, normalizeMedia(a))
+		}
+	}
+	normalized["attachment"] = attachments
+
+	var tags []any
+	for _, t := range asArray(object["tag"]) {
+		tag, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		if icon, ok := asFirst(tag["icon"]).(map[string]any); ok {
+			tag = maps.Clone(tag)
+			tag["icon"] = normalizeMedia(icon)
+		}
+		tags = append(tags, tag)
+	}
+	normalized["tag"] = tags
+
+	// some software sends a content warning without marking it sensitive
+	if summary, _ := object["summary"].(string); strings.TrimSpace(summary) != "" {
+		normalized["sensitive"] = true
+	} else if _, ok := object["sensitive"].(bool); !ok {
+		delete(normalized, "sensitive")
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not encode reply: %w", ErrBadRequest, err)
+	}
+	var reply Reply
+	if err = json.Unmarshal(data, &reply); err != nil {
+		return nil, fmt.Errorf("%w: could not decode reply: %w", ErrBadRequest, err)
+	}
+	return &reply, nil
+}
+
+// Reduces an attachment or icon's url to the string of its first Link
+func normalizeMedia(media map[string]any) map[string]any {
+	media = maps.Clone(media)
+	if link, ok := asFirst(media["url"]).(map[string]any); ok {
+		if _, ok := media["mediaType"]; !ok {
+			media["mediaType"] = link["mediaType"]
+		}
+	}
+	media["url"] = linkHref(media["url"])
+	if _, ok := media["width"].(float64); !ok {
+		delete(media, "width")
+	}
+	if _, ok := media["height"].(float64); !ok {
+		delete(media, "height")
+	}
+	return media
+}
+
+func linkHref(value any) string {
+	switch v := asFirst(value).(type) {
+	case string:
+		return v
+	case map[string]any:
+		href, _ := v["href"].(string)
+		return href
+	}
+	return ""
+}
+
+func asArray(value any) []any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	}
+	return []any{value}
+}
+
+func asFirst(value any) any {
+	if arr := asArray(value); len(arr) > 0 {
+		return arr[0]
+	}
+	return nil
+}
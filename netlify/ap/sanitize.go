@@ -0,0 +1,165 @@
+package ap
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The tags and attributes Mastodon lets through in remote posts. Anything else
+// is unwrapped, keeping its text, except for the elements in droppedElements
+// which are removed along with their contents.
+var allowedElements = map[atom.Atom][]string{
+	atom.P:          {"class"},
+	atom.Br:         nil,
+	atom.Span:       {"class", "translate"},
+	atom.A:          {"href", "class", "translate"},
+	atom.Del:        nil,
+	atom.S:          nil,
+	atom.Pre:        nil,
+	atom.Blockquote: nil,
+	atom.Code:       nil,
+	atom.B:          nil,
+	atom.Strong:     nil,
+	atom.U:          nil,
+	atom.I:          nil,
+	atom.Em:         nil,
+	atom.Ul:         nil,
+	atom.Ol:         {"start", "reversed"},
+	atom.Li:         {"value"},
+	atom.Ruby:       nil,
+	atom.Rt:         nil,
+	atom.Rp:         nil,
+}
+
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Textarea: true,
+	atom.Title:    true,
+	atom.Svg:      true,
+	atom.Math:     true,
+	atom.Head:     true,
+}
+
+var allowedSchemes = map[string]bool{
+	"http": true, "https": true, "dat": true, "dweb": true, "ipfs": true,
+	"ipns": true, "ssb": true, "gopher": true, "xmpp": true, "magnet": true,
+	"gemini": true,
+}
+
+// Microformats and the classes Mastodon uses to lay out links
+func allowedClass(class string) bool {
+	for _, prefix := range []string{"h-", "p-", "u-", "dt-", "e-"} {
+		if strings.HasPrefix(class, prefix) {
+			return true
+		}
+	}
+	switch class {
+	case "mention", "hashtag", "ellipsis", "invisible":
+		return true
+	}
+	return false
+}
+
+// SanitizeHTML reduces remote HTML to the allowlist above, so that replies
+// can't run script or restyle the page they're shown on. Links are marked
+// as user content and open in a new tab.
+func SanitizeHTML(fragment string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return html.EscapeString(fragment)
+	}
+
+	var out strings.Builder
+	for _, n := range nodes {
+		for _, clean := range sanitizeNode(n) {
+			html.Render(&out, clean)
+		}
+	}
+	return out.String()
+}
+
+// Returns what n should be replaced with: itself with cleaned attributes and
+// children, just its cleaned children, or nothing
+func sanitizeNode(n *html.Node) []*html.Node {
+	switch n.Type {
+	case html.TextNode:
+		return []*html.Node{{Type: html.TextNode, Data: n.Data}}
+	case html.ElementNode:
+	default:
+		// comments, doctypes
+		return nil
+	}
+	if droppedElements[n.DataAtom] {
+		return nil
+	}
+
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, sanitizeNode(child)...)
+	}
+	if n.Namespace != "" {
+		return children
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		// like Mastodon, headings become bold paragraphs
+		strong := &html.Node{Type: html.ElementNode, Data: "strong",
+			DataAtom: atom.Strong}
+		for _, child := range children {
+			strong.AppendChild(child)
+		}
+		p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+		p.AppendChild(strong)
+		return []*html.Node{p}
+	}
+	allowedAttrs, ok := allowedElements[n.DataAtom]
+	if !ok {
+		return children
+	}
+
+	clean := &html.Node{Type: html.ElementNode, Data: n.Data, DataAtom: n.DataAtom}
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" || !slices.Contains(allowedAttrs, attr.Key) {
+			continue
+		}
+		switch attr.Key {
+		case "href":
+			u, err := url.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || !allowedSchemes[strings.ToLower(u.Scheme)] {
+				continue
+			}
+		case "class":
+			var classes []string
+			for _, class := range strings.Fields(attr.Val) {
+				if allowedClass(class) {
+					classes = append(classes, class)
+				}
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			attr.Val = strings.Join(classes, " ")
+		}
+		clean.Attr = append(clean.Attr, html.Attribute{Key: attr.Key, Val: attr.Val})
+	}
+	if n.DataAtom == atom.A {
+		clean.Attr = append(clean.Attr,
+			html.Attribute{Key: "rel", Val: "nofollow ugc noopener noreferrer"},
+			html.Attribute{Key: "target", Val: "_blank"})
+	}
+	for _, child := range children {
+		clean.AppendChild(child)
+	}
+	return []*html.Node{clean}
+}
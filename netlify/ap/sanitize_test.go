@@ -0,0 +1,82 @@
+package ap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	const linkAttrs = ` rel="nofollow ugc noopener noreferrer" target="_blank"`
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain paragraph", `<p>hello</p>`, `<p>hello</p>`},
+		{"script dropped with its contents", `<p>hi<script>alert(1)</script></p>`, `<p>hi</p>`},
+		{"style dropped with its contents", `<style>p{color:red}</style><p>x</p>`, `<p>x</p>`},
+		{"svg dropped", `<svg onload="alert(1)"><script>alert(1)</script></svg>ok`, `ok`},
+		{"iframe dropped", `<iframe src="https://evil.example"></iframe>ok`, `ok`},
+		{"unknown element unwrapped", `<div><p>a</p></div>`, `<p>a</p>`},
+		{"img removed", `<img src="x" onerror="alert(1)">text`, `text`},
+		{"event attribute stripped", `<p onclick="alert(1)">a</p>`, `<p>a</p>`},
+		{"style attribute stripped", `<span style="position:fixed">a</span>`, `<span>a</span>`},
+		{"http link kept", `<a href="https://example.com/">x</a>`,
+			`<a href="https://example.com/"` + linkAttrs + `>x</a>`},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"mixed case javascript href", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"padded javascript href", `<a href="  javascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"entity encoded javascript href", `<a href="&#106;avascript:alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"entity encoded colon", `<a href="javascript&colon;alert(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"tab inside scheme", "<a href=\"java\tscript:alert(1)\">x</a>", `<a` + linkAttrs + `>x</a>`},
+		{"data href", `<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"vbscript href", `<a href="vbscript:msgbox(1)">x</a>`, `<a` + linkAttrs + `>x</a>`},
+		{"rel and target replaced", `<a href="https://a.example" target="_self" rel="opener">x</a>`,
+			`<a href="https://a.example"` + linkAttrs + `>x</a>`},
+		{"only allowed classes kept", `<a href="https://a.example" class="mention evil u-url">x</a>`,
+			`<a href="https://a.example" class="mention u-url"` + linkAttrs + `>x</a>`},
+		{"heading becomes bold paragraph", `<h1>Title</h1>`, `<p><strong>Title</strong></p>`},
+		{"nested unknown elements", `<section><article><p><b>x</b></p></article></section>`,
+			`<p><b>x</b></p>`},
+		{"script nested in allowed element", `<p><em><script>alert(1)</script>x</em></p>`,
+			`<p><em>x</em></p>`},
+		{"unclosed tags", `<p><b>bold`, `<p><b>bold</b></p>`},
+		{"stray closing tag", `a</p>b`, `a<p></p>b`},
+		{"malformed attribute", `<p class="h-entry" onclick=alert(1) x>a</p>`, `<p class="h-entry">a</p>`},
+		{"comment dropped", `a<!-- <script>alert(1)</script> -->b`, `ab`},
+		{"text escaped", `1 &lt; 2 &amp;&amp; <3`, `1 &lt; 2 &amp;&amp; &lt;3`},
+		{"script text escaped when tag broken", `<scr<script>ipt>alert(1)</script>`, `ipt&gt;alert(1)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeHTML(tt.in)
+			if got != tt.want {
+				t.Errorf("SanitizeHTML(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Whatever comes in, nothing that can run script comes out
+func TestSanitizeHTMLNoScript(t *testing.T) {
+	inputs := []string{
+		`<svg><a xlink:href="javascript:alert(1)"><text>x</text></a></svg>`,
+		`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+		`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+		`<a href="https://a.example" onmouseover="alert(1)">x</a>`,
+		`<object data="javascript:alert(1)"></object>`,
+		`<form action="javascript:alert(1)"><button>x</button></form>`,
+		`<template><script>alert(1)</script></template>`,
+		`<p><a href="java&#x0A;script:alert(1)">x</a></p>`,
+		`<<script>script>alert(1)<</script>/script>`,
+	}
+	for _, in := range inputs {
+		got := strings.ToLower(SanitizeHTML(in))
+		for _, bad := range []string{"<script", "<img", "<svg", "<style",
+			"onerror", "onmouseover", "javascript:", "<object", "<form"} {
+			if strings.Contains(got, bad) {
+				t.Errorf("SanitizeHTML(%q) = %q, contains %q", in, got, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Software that shows an Article as little more than a link, so its users get
+// a Note preview instead
+var noteOnlySoftware = []string{"bridgy-fed", "threads"}
+
+var nodeInfoClient = &http.Client{Timeout: 5 * time.Second}
+
+// Looks up the name of the server software an actor is on through NodeInfo
+func FetchSoftwareName(actorID string) (string, error) {
+	actorURL, err := url.Parse(actorID)
+	if err != nil {
+		return "", fmt.Errorf("could not parse actor ID: %w", err)
+	}
+
+	var wellKnown struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	err = getJSON("https://"+actorURL.Host+"/.well-known/nodeinfo", &wellKnown)
+	if err != nil {
+		return "", err
+	}
+	var nodeInfoURL string
+	for _, link := range wellKnown.Links {
+		if strings.HasPrefix(link.Rel, "http://nodeinfo.diaspora.software/ns/schema/") {
+			// links are usually ordered by version; keep the newest
+			nodeInfoURL = link.Href
+		}
+	}
+	if nodeInfoURL == "" {
+		return "", errors.New("no nodeinfo schema link")
+	}
+
+	var nodeInfo struct {
+		Software struct {
+			Name string `json:"name"`
+		} `json:"software"`
+	}
+	err = getJSON(nodeInfoURL, &nodeInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(nodeInfo.Software.Name), nil
+}
+
+// Whether an actor should be sent a Note preview rather than an Article
+func PrefersNotes(actor *Actor, softwareName string) bool {
+	// Bluesky users bridged through Bridgy Fed only see short posts
+	if strings.HasPrefix(actor.Id, "https://bsky.brid.gy/") {
+		return true
+	}
+	return slices.Contains(noteOnlySoftware, softwareName)
+}
+
+func getJSON(getURL string, v any) error {
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not form request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := nodeInfoClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch %s: %w", getURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("could not fetch %s: %s", getURL, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode %s: %w", getURL, err)
+	}
+	return nil
+}
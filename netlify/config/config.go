@@ -0,0 +1,319 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	blog "github.com/maxbanister/blog"
+)
+
+type Config struct {
+	// where the site is served from, without a trailing slash
+	BaseURL    string `toml:"base_url"`
+	SiteName   string `toml:"site_name"`
+	Repository string `toml:"repository"`
+	// what nodeinfo names the software, lowercase letters, digits and dashes
+	Software string   `toml:"software"`
+	LogLevel string   `toml:"log_level"`
+	Storage  Storage  `toml:"storage"`
+	Features Features `toml:"features"`
+	Actors   []Actor  `toml:"actors"`
+	// the site itself, which signs the requests it makes on its own account.
+	// Until it has a key in keys.toml, the default actor signs them.
+	InstanceActor Actor `toml:"instance_actor"`
+	// servers refused everything, subdomains included
+	BlockedDomains []string `toml:"blocked_domains"`
+}
+
+type Storage struct {
+	Backend   string `toml:"backend"`
+	ProjectID string `toml:"project_id"`
+	// service account credentials, which only ever come from the environment
+	ClientEmail  string `toml:"-"`
+	ClientID     string `toml:"-"`
+	PrivateKeyID string `toml:"-"`
+	PrivateKey   string `toml:"-"`
+}
+
+type Features struct {
+	Webmentions     bool `toml:"webmentions"`
+	ArticlePreviews bool `toml:"article_previews"`
+	ReplyBackfill   bool `toml:"reply_backfill"`
+	// require signed requests for our ActivityPub objects and collections
+	AuthorizedFetch bool `toml:"authorized_fetch"`
+}
+
+type Actor struct {
+	Username string `toml:"username"`
+	Name     string `toml:"name"`
+	Summary  string `toml:"summary"`
+	// avatar and header, as paths on this site or full URLs
+	Icon  string `toml:"icon"`
+	Image string `toml:"image"`
+	// the page the actor's profile links to, a path on this site
+	ProfilePage string `toml:"profile_page"`
+	// other accounts, elsewhere on the fediverse, that are also this actor.
+	// Followers can only be moved here from those listed.
+	AlsoKnownAs []string `toml:"also_known_as"`
+	// the account this actor has moved to, if it has
+	MovedTo string `toml:"moved_to"`
+	// from keys.toml, the signing key first
+	Keys []Key `toml:"-"`
+}
+
+type Key struct {
+	// username of the actor the key belongs to
+	Actor string `toml:"actor"`
+	// fragment of the actor's ID naming the key
+	ID           string `toml:"id"`
+	PublicKeyPEM string `toml:"public_key_pem"`
+	// environment variable holding the PKCS #8 private key
+	PrivateKeyEnv string `toml:"private_key_env"`
+	// when a rotated out key stops being published
+	RetireAfter time.Time `toml:"retire_after,omitempty"`
+}
+
+// KeysFile is the layout of keys.toml
+type KeysFile struct {
+	Keys []Key `toml:"keys"`
+}
+
+// Site is loaded once, when a function or script starts, so a bad setting
+// fails every invocation loudly rather than some of them subtly
+var Site = mustLoad()
+
+var usernameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// as nodeinfo allows for software names
+var softwareRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func mustLoad() *Config {
+	c, err := Load(blog.SiteTOML, blog.KeysTOML, os.Getenv)
+	if err != nil {
+		panic("invalid site configuration: " + err.Error())
+	}
+	return c
+}
+
+// Load parses the TOML settings and keys, applies the overrides found
+// through getenv, and validates the result
+func Load(data, keys []byte, getenv func(string) string) (*Config, error) {
+	var c Config
+	if _, err := toml.Decode(string(data), &c); err != nil {
+		return nil, fmt.Errorf("could not parse TOML: %w", err)
+	}
+	var keysFile KeysFile
+	if _, err := toml.Decode(string(keys), &keysFile); err != nil {
+		return nil, fmt.Errorf("could not parse keys TOML: %w", err)
+	}
+	for _, key := range keysFile.Keys {
+		for i := range c.Actors {
+			if strings.EqualFold(key.Actor, c.Actors[i].Username) {
+				c.Actors[i].Keys = append(c.Actors[i].Keys, key)
+			}
+		}
+		if strings.EqualFold(key.Actor, c.InstanceActor.Username) {
+			c.InstanceActor.Keys = append(c.InstanceActor.Keys, key)
+		}
+	}
+
+	// Netlify sets URL to the site's address. It's localhost under netlify
+	// dev, where we still want to federate as the real site.
+	if host := getenv("URL"); host != "" && !strings.Contains(host, "localhost") {
+		c.BaseURL = host
+	}
+	if name := getenv("SITE_NAME"); name != "" {
+		c.SiteName = name
+	}
+	if backend := getenv("STORAGE_BACKEND"); backend != "" {
+		c.Storage.Backend = backend
+	}
+	if projectID := getenv("FIRESTORE_PROJECT_ID"); projectID != "" {
+		c.Storage.ProjectID = projectID
+	}
+	if level := getenv("LOG_LEVEL"); level != "" {
+		c.LogLevel = level
+	}
+	c.Storage.ClientEmail = getenv("GOOGLE_CLIENT_EMAIL")
+	c.Storage.ClientID = getenv("GOOGLE_CLIENT_ID")
+	c.Storage.PrivateKeyID = getenv("GOOGLE_PRIV_KEY_ID")
+	c.Storage.PrivateKey = strings.ReplaceAll(getenv("GOOGLE_PRIV_KEY"), "\\n", "\n")
+
+	features := map[string]*bool{
+		"WEBMENTIONS":      &c.Features.Webmentions,
+		"ARTICLE_PREVIEWS": &c.Features.ArticlePreviews,
+		"REPLY_BACKFILL":   &c.Features.ReplyBackfill,
+		"AUTHORIZED_FETCH": &c.Features.AuthorizedFetch,
+	}
+	for name, enabled := range features {
+		value := getenv("FEATURE_" + name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("FEATURE_%s: %w", name, err)
+		}
+		*enabled = b
+	}
+
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	if c.SiteName == "" {
+		c.SiteName = strings.TrimPrefix(strings.TrimPrefix(c.BaseURL, "https://"), "http://")
+	}
+	if c.Software == "" {
+		c.Software = softwareName(c.Repository)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Names the software after the repository's owner and name, so that forks
+// don't report themselves as the original
+func softwareName(repository string) string {
+	repoURL, err := url.Parse(repository)
+	if err != nil {
+		return "blog"
+	}
+	name := strings.ToLower(strings.ReplaceAll(strings.Trim(repoURL.Path, "/"), "/", "-"))
+	name = strings.TrimSuffix(name, ".git")
+	if !softwareRegex.MatchString(name) {
+		return "blog"
+	}
+	return name
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	baseURL, err := url.Parse(c.BaseURL)
+	if err != nil || (baseURL.Scheme != "https" && baseURL.Scheme != "http") ||
+		baseURL.Host == "" || baseURL.Path != "" {
+		errs = append(errs, fmt.Errorf("base_url %q must be an http(s) URL without a path", c.BaseURL))
+	}
+
+	if !softwareRegex.MatchString(c.Software) {
+		errs = append(errs, fmt.Errorf("software %q may only have lowercase letters, digits and dashes", c.Software))
+	}
+
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+
+	switch c.Storage.Backend {
+	case "firestore":
+		if c.Storage.ProjectID == "" {
+			errs = append(errs, errors.New("storage.project_id must be set for firestore"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown storage.backend %q", c.Storage.Backend))
+	}
+
+	if len(c.Actors) == 0 {
+		errs = append(errs, errors.New("at least one actor must be configured"))
+	}
+	seen := make(map[string]bool)
+	for i, a := range c.Actors {
+		if !usernameRegex.MatchString(a.Username) {
+			errs = append(errs, fmt.Errorf("actors[%d]: invalid username %q", i, a.Username))
+		}
+		if seen[strings.ToLower(a.Username)] {
+			errs = append(errs, fmt.Errorf("actors[%d]: duplicate username %q", i, a.Username))
+		}
+		seen[strings.ToLower(a.Username)] = true
+		if !strings.HasPrefix(a.ProfilePage, "/") {
+			errs = append(errs, fmt.Errorf("actors[%d]: profile_page must be a path on this site", i))
+		}
+		for _, alias := range append([]string{a.MovedTo}, a.AlsoKnownAs...) {
+			aliasURL, err := url.Parse(alias)
+			if alias != "" && (err != nil || aliasURL.Scheme != "https" || aliasURL.Host == "") {
+				errs = append(errs, fmt.Errorf("actors[%d]: %q is not an actor ID", i, alias))
+			}
+		}
+		if len(a.Keys) == 0 {
+			errs = append(errs, fmt.Errorf("actors[%d]: no keys for %q", i, a.Username))
+		}
+		keyIDs := make(map[string]bool)
+		for _, key := range a.Keys {
+			if key.ID == "" || keyIDs[key.ID] {
+				errs = append(errs, fmt.Errorf("%s: missing or duplicate key id %q", a.Username, key.ID))
+			}
+			keyIDs[key.ID] = true
+			if !strings.Contains(key.PublicKeyPEM, "BEGIN PUBLIC KEY") {
+				errs = append(errs, fmt.Errorf("%s#%s: public_key_pem is not a PEM public key", a.Username, key.ID))
+			}
+		}
+		if len(a.Keys) > 0 && a.Keys[0].PrivateKeyEnv == "" {
+			errs = append(errs, fmt.Errorf("%s#%s: the signing key needs private_key_env", a.Username, a.Keys[0].ID))
+		}
+	}
+
+	// named like Mastodon's, after the domain, so may have dots
+	if c.InstanceActor.Username == "" || strings.ContainsAny(c.InstanceActor.Username, "@/#") {
+		errs = append(errs, fmt.Errorf("instance_actor: invalid username %q", c.InstanceActor.Username))
+	}
+	if seen[strings.ToLower(c.InstanceActor.Username)] {
+		errs = append(errs, errors.New("instance_actor: username taken by an actor"))
+	}
+	if len(c.InstanceActor.Keys) > 0 && c.InstanceActor.Keys[0].PrivateKeyEnv == "" {
+		errs = append(errs, errors.New("instance_actor: the signing key needs private_key_env"))
+	}
+	// servers with authorized fetch of their own can't fetch our actors'
+	// keys without a signature, which the default actor can't give them
+	if c.Features.AuthorizedFetch && len(c.InstanceActor.Keys) == 0 {
+		errs = append(errs, errors.New("instance_actor: authorized_fetch needs a key, "+
+			"made by `go run ./scripts/rotate_key -instance` before turning it on"))
+	}
+	return errors.Join(errs...)
+}
+
+// Level is the slog level named by log_level, info if it's unset
+func (c *Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if c.LogLevel == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// IsBlocked reports whether host is, or is under, a blocked domain
+func (c *Config) IsBlocked(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range c.BlockedDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingCredentials lists the storage credentials the environment lacks.
+// They're checked when storage is first used rather than at start, as the
+// scripts that build the site never touch it.
+func (s *Storage) MissingCredentials() []string {
+	var missing []string
+	for name, value := range map[string]string{
+		"GOOGLE_CLIENT_EMAIL": s.ClientEmail,
+		"GOOGLE_CLIENT_ID":    s.ClientID,
+		"GOOGLE_PRIV_KEY_ID":  s.PrivateKeyID,
+		"GOOGLE_PRIV_KEY":     s.PrivateKey,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
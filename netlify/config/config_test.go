@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestSoftwareName(t *testing.T) {
+	tests := []struct {
+		repository string
+		want       string
+	}{
+		{"https://github.com/maxbanister/blog", "maxbanister-blog"},
+		{"https://github.com/Someone/My-Blog.git", "someone-my-blog"},
+		{"https://codeberg.org/someone/blog/", "someone-blog"},
+		{"https://example.com/some_one/blog", "blog"},
+		{"", "blog"},
+	}
+	for _, tt := range tests {
+		if got := softwareName(tt.repository); got != tt.want {
+			t.Errorf("softwareName(%q) = %q, want %q", tt.repository, got, tt.want)
+		}
+	}
+}
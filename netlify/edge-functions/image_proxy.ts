@@ -3,7 +3,12 @@ import type { Config, Context } from "@netlify/edge-functions";
 // This edge function proxies image requests from the user's client to the
 // social media site it's hosted on. Also, if it 404's, we will call the refresh
 // service to refresh the user profile belonging to the fetched image and return
-// the updated profile pic
+// the updated profile pic.
+//
+// Images in replies (attachments and custom emoji) are requested with a
+// colName of "media". There's no profile to refresh for those. The refresh
+// service only confirms the URL is one of the stored reply's images, and only
+// images are passed through.
 
 export default async (req: Request, context: Context) => {
 	const parts = req.url.split("image_proxy/").pop()?.split("/");
@@ -12,11 +17,45 @@ export default async (req: Request, context: Context) => {
 	}
 	const [iconURL, colName, refID] = parts;
 	if (!colName || !iconURL || !refID ||
-		["likes", "shares", "replies"].indexOf(colName) == -1
+		["likes", "shares", "replies", "media"].indexOf(colName) == -1
 	) {
 		return new Response("Bad Request", {"status": 400});
 	}
 
+	const refreshURL = context.site.url +
+		"/.netlify/functions/refresh-profile?iconURL=" + iconURL +
+		"&colName=" + colName +
+		"&refID=" + refID;
+	const refreshInit = {
+		method: "GET",
+		headers: {
+			"Content-Type": "application/json",
+			"Authorization": "" + Netlify.env.get("SELF_API_KEY"),
+			// so refresh-profile's logs can be tied to this request
+			"X-Request-Id": context.requestId,
+		},
+	};
+
+	if (colName === "media") {
+		try {
+			// otherwise we'd proxy any image on the web
+			const checkResp = await fetch(refreshURL, refreshInit);
+			if (checkResp.status !== 200) {
+				return new Response("Not Found", {"status": 404});
+			}
+			const mediaResp = await fetch(await checkResp.text());
+			const contentType = mediaResp.headers.get("Content-Type") || "";
+			// SVGs can carry scripts, which would run on our origin
+			if (mediaResp.ok && contentType.startsWith("image/") &&
+				!contentType.includes("svg")) {
+				return mediaResp;
+			}
+		} catch (error) {
+			// fall through
+		}
+		return new Response("Not Found", {"status": 404});
+	}
+
 	try {
 		const origResp = await fetch(decodeURIComponent(iconURL));
 		if (origResp.status === 200) {
@@ -28,19 +67,7 @@ export default async (req: Request, context: Context) => {
 
 	console.log("Original image link rotten, fetching new - ", iconURL, refID);
 
-	const refreshResp = await fetch(
-		context.site.url +
-		"/.netlify/functions/refresh-profile?iconURL=" + iconURL +
-		"&colName=" + colName +
-		"&refID=" + refID,
-		{
-			method: "GET",
-			headers: {
-				"Content-Type": "application/json",
-				"Authorization": "" + Netlify.env.get("SELF_API_KEY")
-			},
-		}
-	);
+	const refreshResp = await fetch(refreshURL, refreshInit);
 	if (refreshResp.status !== 200) {
 		return refreshResp;
 	}
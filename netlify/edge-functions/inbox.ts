@@ -30,6 +30,6 @@ export default async (req: Request, context: Context) => {
 };
 
 export const config: Config = {
-	path: "/ap/inbox",
+	path: ["/ap/inbox", "/ap/user/*/inbox"],
 	onError: "bypass"
 };
\ No newline at end of file
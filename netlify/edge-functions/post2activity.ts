@@ -20,6 +20,18 @@ export default async (req: Request, context: Context) => {
 	for (const item of outbox.orderedItems) {
 		if (item.object.id == req.url) {
 			console.log(item.object);
+			if (item.type == "Delete") {
+				const tombstone = {
+					"@context": "https://www.w3.org/ns/activitystreams",
+					"id": item.object.id,
+					"type": "Tombstone",
+					"formerType": "Note"
+				};
+				return new Response(JSON.stringify(tombstone), {
+					status: 410,
+					headers: {"Content-Type": "application/activity+json"}
+				});
+			}
 			return new Response(JSON.stringify(item.object), {
 				headers: {"Content-Type": "application/activity+json"}
 			});
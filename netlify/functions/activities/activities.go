@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	blog "github.com/maxbanister/blog"
+	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func main() {
+	lambda.Start(handle)
+}
+
+type outboxItem struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Object struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"object"`
+}
+
+func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	idSuffix := request.QueryStringParameters["id"]
+	activityID := GetHostSite() + "/ap/activities/" + idSuffix
+	slog.Info("got request", "activity", activityID)
+
+	// Creates, Updates and Deletes of posts come straight from the outbox
+	payload, tombstone, err := findOutboxActivity(activityID, idSuffix)
+	if err != nil {
+		return GetErrorResp(err)
+	}
+	if tombstone != nil {
+		body, _ := json.Marshal(tombstone)
+		return activityResp(http.StatusGone, string(body)), nil
+	}
+	if payload != "" {
+		return activityResp(http.StatusOK, payload), nil
+	}
+
+	// everything else was stored when it was sent
+	slug, err := kv.ActivitySlug(activityID)
+	if err != nil {
+		return GetLambdaResp(fmt.Errorf("%w: %w", ErrBadRequest, err))
+	}
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return GetErrorResp(
+			fmt.Errorf("could not start firestore client: %w", err),
+		)
+	}
+	defer client.Close()
+
+	doc, err := client.Collection("activities").Doc(slug).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return GetErrorResp(fmt.Errorf("error looking up activity: %w", err))
+		}
+		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+	var stored kv.StoredActivity
+	err = doc.DataTo(&stored)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not convert doc to struct: %w", err))
+	}
+
+	if !servable(&stored) {
+		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+
+	return activityResp(http.StatusOK, stored.Payload), nil
+}
+
+// Follow relationships are fine to reveal, but never serve private posts. An
+// Undo is only as visible as what it undoes, which it's addressed like.
+func servable(stored *kv.StoredActivity) bool {
+	switch stored.Type {
+	case "Accept", "Reject", "Follow":
+		return true
+	case "Undo":
+		var undo struct {
+			Object struct {
+				Type string `json:"type"`
+			} `json:"object"`
+		}
+		err := json.Unmarshal([]byte(stored.Payload), &undo)
+		if err == nil && undo.Object.Type == "Follow" {
+			return true
+		}
+	}
+	return stored.Public
+}
+
+// Looks for activityID in the embedded outbox. If the activity belongs to a
+// post that has since been deleted, a Tombstone for the post is returned.
+func findOutboxActivity(activityID, idSuffix string) (string, map[string]any, error) {
+	var outbox struct {
+		OrderedItems []json.RawMessage `json:"orderedItems"`
+	}
+	outboxJSON, err := blog.OutboxJSON()
+	if err != nil {
+		return "", nil, err
+	}
+	err = json.Unmarshal(outboxJSON, &outbox)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not decode outbox JSON: %w", err)
+	}
+
+	for _, rawItem := range outbox.OrderedItems {
+		var item outboxItem
+		err := json.Unmarshal(rawItem, &item)
+		if err != nil {
+			slog.Warn("could not decode outbox item", "err", err)
+			continue
+		}
+		if item.Id == activityID {
+			return string(rawItem), nil, nil
+		}
+		if item.Type != "Delete" {
+			continue
+		}
+
+		// the Create or an Update of a post whose Delete is now in the outbox
+		postSlug := path.Base(item.Object.Id)
+		if idSuffix == "create-"+postSlug || isUpdateOf(idSuffix, postSlug) {
+			return "", map[string]any{
+				"@context":   "https://www.w3.org/ns/activitystreams",
+				"id":         item.Object.Id,
+				"type":       "Tombstone",
+				"formerType": "Note",
+			}, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+// Update IDs are suffixed with the post's modification time
+func isUpdateOf(idSuffix, postSlug string) bool {
+	modTime, found := strings.CutPrefix(idSuffix, "update-"+postSlug+"-")
+	if !found {
+		return false
+	}
+	_, err := strconv.ParseInt(modTime, 10, 64)
+	return err == nil
+}
+
+func activityResp(code int, body string) *LambdaResponse {
+	return &events.APIGatewayProxyResponse{
+		StatusCode: code,
+		Headers: map[string]string{
+			"Content-Type": "application/activity+json",
+		},
+		Body: body,
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/kv"
+)
+
+func TestServable(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored kv.StoredActivity
+		want   bool
+	}{
+		{"public create", kv.StoredActivity{Type: "Create", Public: true}, true},
+		{"private create", kv.StoredActivity{Type: "Create"}, false},
+		{"accept", kv.StoredActivity{Type: "Accept"}, true},
+		{"follow", kv.StoredActivity{Type: "Follow"}, true},
+		{"undo of a follow", kv.StoredActivity{Type: "Undo",
+			Payload: `{"type":"Undo","object":{"type":"Follow"}}`}, true},
+		{"undo of a private like", kv.StoredActivity{Type: "Undo",
+			Payload: `{"type":"Undo","object":{"type":"Like"}}`}, false},
+		{"undo of a public announce", kv.StoredActivity{Type: "Undo", Public: true,
+			Payload: `{"type":"Undo","object":{"type":"Announce"}}`}, true},
+		{"undo with unreadable payload", kv.StoredActivity{Type: "Undo",
+			Payload: `{`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := servable(&tt.stored); got != tt.want {
+				t.Errorf("servable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
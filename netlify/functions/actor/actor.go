@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
+	"github.com/maxbanister/blog/netlify/logging"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func main() {
+	lambda.Start(handleActor)
+}
+
+// Serves a local actor's document from the registry, or the instance
+// actor's. Browsers are sent to the actor's profile page instead.
+func handleActor(request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	actor := ap.FindLocalActor(request.QueryStringParameters["name"])
+	isInstance := request.QueryStringParameters["instance"] != ""
+	if isInstance && len(ap.InstanceActor.Keys) > 0 {
+		actor = ap.InstanceActor
+	}
+	if actor == nil {
+		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+
+	accept := strings.ToLower(request.Headers["accept"])
+	if strings.Contains(accept, "text/html") && !strings.Contains(accept, "json") {
+		return &events.APIGatewayProxyResponse{
+			StatusCode: http.StatusFound,
+			Headers:    map[string]string{"Location": GetHostSite() + actor.ProfilePage},
+		}, nil
+	}
+
+	document := actor.Document()
+	// the instance actor's key is what servers check our own fetches
+	// against, so it's always served in full
+	if config.Site.Features.AuthorizedFetch && !isInstance {
+		if request.Headers["signature"] == "" {
+			// servers fetching the key to check our signatures can't be
+			// asked to sign in turn, or neither would get anywhere
+			document = keyOnly(document)
+		} else if _, err := ap.VerifyFetch(&request, "name"); err != nil {
+			return GetLambdaResp(err)
+		}
+	}
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not marshal actor: %w", err))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/activity+json",
+		},
+		Body: string(body),
+	}, nil
+}
+
+// What an unsigned request gets: enough to check the actor's signatures and
+// deliver to it, and nothing more
+func keyOnly(document map[string]any) map[string]any {
+	restricted := make(map[string]any)
+	for _, prop := range []string{
+		"@context", "id", "type", "preferredUsername", "inbox", "endpoints",
+		"publicKey",
+	} {
+		if value, ok := document[prop]; ok {
+			restricted[prop] = value
+		}
+	}
+	return restricted
+}
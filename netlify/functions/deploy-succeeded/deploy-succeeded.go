@@ -5,6 +5,9 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,22 +16,28 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	blog "github.com/maxbanister/blog"
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
-	"google.golang.org/api/iterator"
 )
 
 func main() {
 	lambda.Start(handleDeploy)
 }
 
-func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
-	fmt.Println("Deploy successful")
+func handleDeploy(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	slog.Info("deploy succeeded")
 
 	var outbox struct {
 		OrderedItems []json.RawMessage `json:"orderedItems"`
 	}
-	err := json.Unmarshal(blog.OutboxJSON, &outbox)
+	outboxJSON, err := blog.OutboxJSON()
+	if err != nil {
+		return GetErrorResp(err)
+	}
+	err = json.Unmarshal(outboxJSON, &outbox)
 	if err != nil {
 		return GetErrorResp(fmt.Errorf("could not decode outbox JSON: %w", err))
 	}
@@ -36,9 +45,16 @@ func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
 	type OutboxItem struct {
 		Typ     string `json:"type"`
 		ID      string `json:"id"`
+		Actor   string `json:"actor"`
 		Payload string `json:"-"`
-		Object  struct {
-			Updated string `json:"updated"`
+		// Note version of an Article, for servers that can't display one
+		PreviewPayload string `json:"-"`
+		Object         struct {
+			Type      string `json:"type"`
+			URL       string `json:"url"`
+			Content   string `json:"content"`
+			Published string `json:"published"`
+			Updated   string `json:"updated"`
 		} `json:"object"`
 	}
 	var validOutboxItems []*OutboxItem
@@ -48,20 +64,27 @@ func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
 		var decodedItem OutboxItem
 		err := json.Unmarshal(outboxActivity, &decodedItem)
 		if err != nil {
-			fmt.Println("could not decode outbox item:", err.Error())
+			slog.Warn("could not decode outbox item", "err", err)
 			continue
 		}
 
+		// compared as times, as the outbox's offsets needn't match ours
 		twoDaysAgo := time.Now().Add(-48 * time.Hour)
-		twoDaysAgoStr := twoDaysAgo.Format("2006-01-02T15:04:05-07:00")
 		gotUpdatePost := false
-		if decodedItem.Object.Updated >= twoDaysAgoStr {
+		updated, err := time.Parse(time.RFC3339, decodedItem.Object.Updated)
+		if err == nil && updated.After(twoDaysAgo) {
 			gotUpdatePost = decodedItem.Typ == "Update"
 		}
 		// Send out all the deletes every time
 		if decodedItem.Typ == "Delete" || !createPostSeen || gotUpdatePost {
-			fmt.Printf("Queuing %s of %s\n", decodedItem.Typ, decodedItem.ID)
+			slog.Info("queuing", "type", decodedItem.Typ, "id", decodedItem.ID)
 			decodedItem.Payload = string(outboxActivity)
+			if decodedItem.Object.Type == "Article" && config.Site.Features.ArticlePreviews {
+				decodedItem.PreviewPayload, err = getPreviewPayload(outboxActivity)
+				if err != nil {
+					slog.Warn("could not make note preview", "err", err)
+				}
+			}
 			validOutboxItems = append(validOutboxItems, &decodedItem)
 
 			if decodedItem.Typ == "Create" {
@@ -70,15 +93,6 @@ func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
 		}
 	}
 
-	if len(validOutboxItems) == 0 {
-		return &events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Body:       "outbox empty",
-		}, nil
-	}
-
-	// get followers from firestore
-	ctx := context.Background()
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
 		return GetErrorResp(
@@ -87,52 +101,85 @@ func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
 	}
 	defer client.Close()
 
-	var followers []*ap.Actor
-	iter := client.Collection("followers").Documents(ctx)
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+	// actors whose name, avatar, keys etc. changed go out as Updates too
+	profileUpdates, err := changedProfiles(ctx, client)
+	if err != nil {
+		// the posts can still go; the profiles are compared again next deploy
+		slog.Error("could not check for profile changes", "err", err)
+	}
+	for _, update := range profileUpdates {
+		slog.Info("queuing", "type", "Update", "id", update.actor.ID())
+		item := &OutboxItem{
+			Typ:     update.activity.Type,
+			ID:      update.activity.Id,
+			Actor:   update.actor.ID(),
+			Payload: update.payload,
 		}
-		if err != nil {
-			return GetErrorResp(
-				fmt.Errorf("could not call iter next on collection: %w", err),
-			)
+		item.Object.Type = "Person"
+		validOutboxItems = append(validOutboxItems, item)
+	}
+
+	if len(validOutboxItems) == 0 {
+		return &events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Body:       "outbox empty",
+		}, nil
+	}
+
+	// each post goes to the followers of the actor who published it
+	followersByActor := make(map[*ap.LocalActor][]*ap.Actor)
+	var followers []*ap.Actor
+	for _, outboxItem := range validOutboxItems {
+		sender := postActor(outboxItem.Actor)
+		if _, ok := followersByActor[sender]; ok {
+			continue
 		}
-		var follower ap.Actor
-		err = doc.DataTo(&follower)
+		senderFollowers, err := kv.GetFollowers(ctx, client, sender)
 		if err != nil {
-			return GetErrorResp(
-				fmt.Errorf("could not convert doc to Actor: %w", err),
-			)
+			return GetErrorResp(err)
 		}
-		followers = append(followers, &follower)
+		followersByActor[sender] = senderFollowers
+		followers = append(followers, senderFollowers...)
+	}
+
+	// only Articles need to know what software the followers run
+	var softwareNames map[string]string
+	hasArticle := slices.ContainsFunc(validOutboxItems, func(i *OutboxItem) bool {
+		return i.PreviewPayload != ""
+	})
+	if hasArticle {
+		softwareNames = getSoftwareNames(followers)
 	}
 
 	var wg sync.WaitGroup
 
 	// broadcast to followers
-	fmt.Printf("Broadcasting %d changes...\n", len(validOutboxItems))
+	slog.Info("broadcasting", "changes", len(validOutboxItems))
 	for _, outboxItem := range validOutboxItems {
-		for _, follower := range followers {
+		for _, follower := range followersByActor[postActor(outboxItem.Actor)] {
 			// Bluesky doesn't support editing posts
 			isBskyUsr := strings.HasPrefix(follower.Id, "https://bsky.brid.gy/")
-			if outboxItem.Typ == "Update" && isBskyUsr {
+			isPostUpdate := outboxItem.Typ == "Update" && outboxItem.Object.Type != "Person"
+			if isPostUpdate && isBskyUsr {
 				continue
 			}
 
+			payload := outboxItem.Payload
+			software := softwareNames[follower.Id]
+			if outboxItem.PreviewPayload != "" && ap.PrefersNotes(follower, software) {
+				payload = outboxItem.PreviewPayload
+			}
+
 			wg.Add(1)
 
 			go func(follower ap.Actor) {
 				defer wg.Done()
-				err = ap.SendActivity(outboxItem.Payload, &follower)
+				err := ap.SendActivity(payload, &follower)
 				if err != nil {
-					fmt.Printf("failed to send %s to %s: %s\n", outboxItem.ID,
-						follower.Id, err.Error())
+					slog.Warn("failed to send", "id", outboxItem.ID,
+						"to", follower.Id, "err", err)
 				} else {
-					fmt.Printf("successfully sent %s to %s\n", outboxItem.ID,
-						follower.Id)
+					slog.Info("sent", "id", outboxItem.ID, "to", follower.Id)
 				}
 			}(*follower)
 		}
@@ -140,8 +187,112 @@ func handleDeploy(request LambdaRequest) (*LambdaResponse, error) {
 
 	wg.Wait()
 
+	for _, update := range profileUpdates {
+		err := kv.SaveActivity(update.activity, update.payload)
+		if err != nil {
+			slog.Warn("could not save activity", "err", err)
+		}
+		err = saveProfileHash(ctx, client, update)
+		if err != nil {
+			slog.Error("could not save profile hash", "err", err)
+		}
+	}
+
+	if !config.Site.Features.Webmentions {
+		return &events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Body:       "ok",
+		}, nil
+	}
+	var mentionSources []*mentionSource
+	for _, outboxItem := range validOutboxItems {
+		if outboxItem.Typ == "Delete" || outboxItem.Object.URL == "" {
+			continue
+		}
+		version := outboxItem.Object.Updated
+		if version == "" {
+			version = outboxItem.Object.Published
+		}
+		mentionSources = append(mentionSources, &mentionSource{
+			URL:     outboxItem.Object.URL,
+			Content: outboxItem.Object.Content,
+			Version: version,
+		})
+	}
+	slog.Info("sending webmentions", "posts", len(mentionSources))
+	// they're sent last and bounded by the function's own deadline; what
+	// isn't sent in time isn't recorded, so goes out on the next deploy
+	sendCtx, cancel := context.WithDeadline(ctx, webmentionDeadline(ctx))
+	defer cancel()
+	sendWebmentions(ctx, sendCtx, client, mentionSources, GetHostSite())
+
 	return &events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Body:       "ok",
 	}, nil
 }
+
+// The local actor a post in the outbox was published by
+func postActor(actorID string) *ap.LocalActor {
+	if actor := ap.LocalActorByID(actorID); actor != nil {
+		return actor
+	}
+	return ap.DefaultActor()
+}
+
+func getPreviewPayload(outboxActivity []byte) (string, error) {
+	var article ap.Object
+	activity := ap.Activity{Object: &article}
+	err := json.Unmarshal(outboxActivity, &activity)
+	if err != nil {
+		return "", err
+	}
+	activity.Object = ap.NotePreview(&article)
+	return activity.Payload()
+}
+
+// Finds out which server software each follower is on, looking up each host
+// only once
+func getSoftwareNames(followers []*ap.Actor) map[string]string {
+	byHost := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, follower := range followers {
+		host := getHost(follower.Id)
+		mu.Lock()
+		_, seen := byHost[host]
+		byHost[host] = ""
+		mu.Unlock()
+		if seen {
+			continue
+		}
+
+		wg.Add(1)
+		go func(actorID string) {
+			defer wg.Done()
+			name, err := ap.FetchSoftwareName(actorID)
+			if err != nil {
+				slog.Debug("no software name", "host", host, "err", err)
+				return
+			}
+			mu.Lock()
+			byHost[host] = name
+			mu.Unlock()
+		}(follower.Id)
+	}
+	wg.Wait()
+
+	softwareNames := make(map[string]string, len(followers))
+	for _, follower := range followers {
+		softwareNames[follower.Id] = byHost[getHost(follower.Id)]
+	}
+	return softwareNames
+}
+
+func getHost(actorID string) string {
+	actorURL, err := url.Parse(actorID)
+	if err != nil {
+		return ""
+	}
+	return actorURL.Host
+}
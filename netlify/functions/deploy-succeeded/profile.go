@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/maxbanister/blog/netlify/ap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Keeps a hash of each actor's document as of the last deploy, by username
+const profilesCollection = "profiles"
+
+type deployedProfile struct {
+	Hash string
+}
+
+// An actor whose document changed with this deploy, and the Update telling
+// its followers
+type profileUpdate struct {
+	actor    *ap.LocalActor
+	hash     string
+	activity *ap.Activity
+	payload  string
+}
+
+// Compares each actor's document to the one last deployed. Actors seen for
+// the first time are only recorded, as there's nothing their followers could
+// have cached.
+func changedProfiles(ctx context.Context, client *firestore.Client) ([]*profileUpdate, error) {
+	var updates []*profileUpdate
+	for _, actor := range ap.LocalActors {
+		document := actor.Document()
+		hash, err := hashDocument(document)
+		if err != nil {
+			return nil, err
+		}
+
+		docRef := client.Collection(profilesCollection).Doc(actor.Username)
+		snapshot, err := docRef.Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			_, err = docRef.Set(ctx, deployedProfile{Hash: hash})
+			if err != nil {
+				return nil, fmt.Errorf("could not record profile of %s: %w",
+					actor.Username, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read profile of %s: %w",
+				actor.Username, err)
+		}
+		var deployed deployedProfile
+		err = snapshot.DataTo(&deployed)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert doc to profile: %w", err)
+		}
+		if deployed.Hash == hash {
+			continue
+		}
+
+		update := ap.NewUpdate(actor, document)
+		payload, err := update.Payload()
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, &profileUpdate{actor, hash, update, payload})
+	}
+	return updates, nil
+}
+
+// Records that an actor's followers were sent its current document, so the
+// next deploy only sends it again if it changes
+func saveProfileHash(ctx context.Context, client *firestore.Client, update *profileUpdate) error {
+	_, err := client.Collection(profilesCollection).Doc(update.actor.Username).
+		Set(ctx, deployedProfile{Hash: update.hash})
+	if err != nil {
+		return fmt.Errorf("could not record profile of %s: %w",
+			update.actor.Username, err)
+	}
+	return nil
+}
+
+// Maps marshal with sorted keys, so equal documents hash the same
+func hashDocument(document map[string]any) (string, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal actor: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
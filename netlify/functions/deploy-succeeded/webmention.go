@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/maxbanister/blog/netlify/mf2"
+	. "github.com/maxbanister/blog/netlify/util"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The most of a page we'll read looking for links or an endpoint
+const maxPageSize = 1 << 20
+
+// Left of the function's time once sending stops, to record what was sent
+const recordTime = 3 * time.Second
+
+// How long sending may take when the function has no deadline
+const defaultSendTime = 20 * time.Second
+
+var webmentionClient = &http.Client{Timeout: 5 * time.Second}
+
+var linkHeaderEntry = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^;,]+)*)`)
+var linkHeaderRel = regexp.MustCompile(`(?i)(?:^|;)\s*rel\s*=\s*(?:"([^"]*)"|([^\s";]+))`)
+
+// A post whose links are owed webmentions. Version is when it was last
+// changed, so an edit sends them again.
+type mentionSource struct {
+	URL     string
+	Content string
+	Version string
+}
+
+// What's recorded for each target a post has sent a webmention to
+type sentMention struct {
+	Target  string
+	Version string
+}
+
+// Sends webmentions (https://www.w3.org/TR/webmention/#sending-webmentions)
+// to the external pages each post links to, and to those it stopped linking
+// to since the last time, so they can drop theirs. What's been sent is kept
+// in the webmentions collection so redeploys don't send it again. Requests
+// stop at sendCtx's deadline; ctx is for recording what was sent.
+func sendWebmentions(ctx, sendCtx context.Context, client *firestore.Client, sources []*mentionSource, host string) {
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := sendSourceWebmentions(ctx, sendCtx, client, source, host)
+			if err != nil {
+				slog.Error("failed to send webmentions", "source", source.URL,
+					"err", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// When to stop sending so there's time left to record what was sent
+func webmentionDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline.Add(-recordTime)
+	}
+	return time.Now().Add(defaultSendTime)
+}
+
+func sendSourceWebmentions(ctx, sendCtx context.Context, client *firestore.Client, source *mentionSource, host string) error {
+	sourceURI, err := url.Parse(source.URL)
+	if err != nil {
+		return fmt.Errorf("malformed post URL: %w", err)
+	}
+	docRef := client.Collection("webmentions").Doc(Sluggify(*sourceURI))
+	var record struct {
+		Source string
+		Sent   map[string]sentMention
+	}
+	doc, err := docRef.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("error looking up sent webmentions: %w", err)
+	}
+	if err == nil {
+		if err := doc.DataTo(&record); err != nil {
+			return fmt.Errorf("could not convert document to struct: %w", err)
+		}
+	}
+
+	targets := externalLinks(sendCtx, source, host)
+	var pending []string
+	for _, target := range targets {
+		if sent, ok := record.Sent[targetKey(target)]; !ok || sent.Version != source.Version {
+			pending = append(pending, target)
+		}
+	}
+	for _, sent := range record.Sent {
+		if !slices.Contains(targets, sent.Target) {
+			// let it know the link is gone
+			slog.Info("no longer linking", "target", sent.Target)
+			pending = append(pending, sent.Target)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	updates := make(map[string]any)
+	for _, target := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := sendWebmention(sendCtx, source.URL, target)
+			if err != nil {
+				slog.Warn("failed to send webmention", "source", source.URL,
+					"target", target, "err", err)
+				return
+			}
+			slog.Info("sent webmention", "source", source.URL, "target", target)
+			mu.Lock()
+			defer mu.Unlock()
+			if slices.Contains(targets, target) {
+				updates[targetKey(target)] = sentMention{target, source.Version}
+			} else {
+				updates[targetKey(target)] = firestore.Delete
+			}
+		}()
+	}
+	wg.Wait()
+	if len(updates) == 0 {
+		return nil
+	}
+
+	_, err = docRef.Set(ctx, map[string]any{
+		"Source": source.URL,
+		"Sent":   updates,
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("could not record sent webmentions: %w", err)
+	}
+	return nil
+}
+
+// Target URLs make poor field names, so they're keyed by their slug
+func targetKey(target string) string {
+	targetURI, _ := url.Parse(target)
+	return Sluggify(*targetURI)
+}
+
+// The http(s) links in a post that lead off this site. The post's content in
+// the outbox may only be a preview, so the links in the rendered page's
+// h-entry content are read as well.
+func externalLinks(ctx context.Context, source *mentionSource, host string) []string {
+	base, _ := url.Parse(source.URL)
+	links, err := mf2.Links(strings.NewReader(source.Content), base)
+	if err != nil {
+		slog.Warn("could not parse post content", "err", err)
+	}
+	pageLinks, err := renderedLinks(ctx, base)
+	if err != nil {
+		slog.Warn("could not read links", "source", source.URL, "err", err)
+	}
+	links = append(links, pageLinks...)
+
+	_, hostName, _ := strings.Cut(host, "//")
+	var external []string
+	for _, link := range links {
+		linkURI, err := url.Parse(link)
+		if err != nil || (linkURI.Scheme != "https" && linkURI.Scheme != "http") ||
+			linkURI.Host == "" || linkURI.Host == hostName {
+			continue
+		}
+		linkURI.Fragment = ""
+		if link = linkURI.String(); !slices.Contains(external, link) {
+			external = append(external, link)
+		}
+	}
+	return external
+}
+
+func renderedLinks(ctx context.Context, postURI *url.URL) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", postURI.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := webmentionClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("got %s", resp.Status)
+	}
+
+	items, err := mf2.Parse(io.LimitReader(resp.Body, maxPageSize), resp.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if !item.HasType("h-entry") {
+			continue
+		}
+		var links []string
+		for _, v := range item.Properties["content"] {
+			if content, ok := v.(*mf2.HTML); ok {
+				contentLinks, err := mf2.Links(strings.NewReader(content.HTML),
+					resp.Request.URL)
+				if err != nil {
+					return nil, err
+				}
+				links = append(links, contentLinks...)
+			}
+		}
+		return links, nil
+	}
+	return nil, nil
+}
+
+// Discovers the target's endpoint and notifies it. Targets without one are
+// skipped quietly, since most pages don't take webmentions.
+func sendWebmention(ctx context.Context, source, target string) error {
+	endpoint, err := discoverEndpoint(ctx, target)
+	if err != nil {
+		return fmt.Errorf("could not discover endpoint: %w", err)
+	}
+	if endpoint == "" {
+		return nil
+	}
+
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := webmentionClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("endpoint returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// Looks for the target's endpoint in its Link headers, then in the first
+// <link> or <a> with rel="webmention" in its HTML
+func discoverEndpoint(ctx context.Context, target string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := webmentionClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("got %s", resp.Status)
+	}
+	// relative endpoints are relative to wherever redirects left us
+	base := resp.Request.URL
+
+	for _, header := range resp.Header.Values("Link") {
+		for _, entry := range linkHeaderEntry.FindAllStringSubmatch(header, -1) {
+			for _, rel := range linkHeaderRel.FindAllStringSubmatch(entry[2], -1) {
+				rels := strings.Fields(strings.ToLower(rel[1] + rel[2]))
+				if slices.Contains(rels, "webmention") {
+					return resolveEndpoint(base, entry[1])
+				}
+			}
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return "", nil
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return "", err
+	}
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+	if href, ok := findEndpointElement(doc); ok {
+		return resolveEndpoint(base, href)
+	}
+	return "", nil
+}
+
+func findEndpointElement(n *html.Node) (string, bool) {
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Link || n.DataAtom == atom.A) {
+		var rel, href string
+		hasHref := false
+		for _, a := range n.Attr {
+			switch a.Key {
+			case "rel":
+				rel = a.Val
+			case "href":
+				href, hasHref = a.Val, true
+			}
+		}
+		if hasHref && slices.Contains(strings.Fields(strings.ToLower(rel)), "webmention") {
+			return href, true
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if href, ok := findEndpointElement(c); ok {
+			return href, true
+		}
+	}
+	return "", false
+}
+
+// An empty href means the target is its own endpoint
+func resolveEndpoint(base *url.URL, href string) (string, error) {
+	endpoint, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return "", fmt.Errorf("endpoint %s not http(s)", endpoint)
+	}
+	return endpoint.String(), nil
+}
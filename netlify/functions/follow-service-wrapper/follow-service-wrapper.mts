@@ -11,9 +11,6 @@ export default async (req: Request, context: Context) => {
 		}
 	);
 
-	console.log("Auth hdr:", authHdr);
-	console.log("Env var key:", process.env.SELF_API_KEY);
-
 	if (!authHdr || !process.env.SELF_API_KEY) {
 		return forbiddenResp;
 	}
@@ -25,25 +22,33 @@ export default async (req: Request, context: Context) => {
 	}
 
 	const body = await req.text();
+	// so the follow service's logs share the inbox's request ID
+	const requestID = req.headers.get("X-Request-Id") ?? context.requestId;
 
 	// don't stop function until follow service has sent AcceptRequest
-	context.waitUntil(callFollowService(req, authHdr, body));
+	context.waitUntil(callFollowService(req, authHdr, body, requestID));
 
 	return new Response("200 OK", { status: 200 });
 };
 
-async function callFollowService(req: Request, authHdr: string, body: string) {
+async function callFollowService(req: Request, authHdr: string, body: string,
+	requestID: string) {
 	// give inbox function time to return 200 response
 	await new Promise(resolve => setTimeout(resolve, 500));
 
-	console.log("Calling follow service");
+	console.log(JSON.stringify({
+		level: "INFO",
+		msg: "calling follow service",
+		request_id: requestID,
+	}));
 
 	await fetch(process.env.URL + "/.netlify/functions/follow-service", {
 		method: "POST",
 		body: body,
 		headers: {
 			"Content-Type": "application/json",
-			"Authorization": authHdr
+			"Authorization": authHdr,
+			"X-Request-Id": requestID,
 		},
 	});
 }
@@ -4,12 +4,14 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	. "github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
 )
 
@@ -18,19 +20,20 @@ func main() {
 }
 
 func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
-	fmt.Println("Got accept follow request")
+	logging.Start(request.Headers)
+	slog.Info("got accept follow request")
 
 	authHdr := []byte(request.Headers["authorization"])
 	selfAPIKey := []byte(os.Getenv("SELF_API_KEY"))
 	if subtle.ConstantTimeCompare(authHdr, selfAPIKey) == 0 {
-		fmt.Println("Authorization header did not match key")
+		slog.Warn("authorization header did not match key")
 		return &events.APIGatewayProxyResponse{StatusCode: 400}, nil
 	}
 
 	var followReq FollowServiceRequest
 	err := json.Unmarshal([]byte(request.Body), &followReq)
 	if err != nil {
-		fmt.Println("could not unmarshal json:", err)
+		slog.Warn("could not unmarshal json", "err", err)
 		return &events.APIGatewayProxyResponse{StatusCode: 400}, nil
 	}
 
@@ -39,29 +42,51 @@ func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error)
 	var actor Actor
 	err = json.Unmarshal(followReq.Actor, &actor)
 	if err != nil {
-		fmt.Println("could not unmarshal actor:", err)
+		slog.Warn("could not unmarshal actor", "err", err)
 		return &events.APIGatewayProxyResponse{StatusCode: 400}, nil
 	}
 
-	hostSite := GetHostSite()
-	AcceptRequest(hostSite, followObj, &actor)
+	AnswerRequest(followObj, &actor, followReq.Reject)
 
 	return &events.APIGatewayProxyResponse{StatusCode: 200}, nil
 }
 
-func AcceptRequest(hostSite, followReqBody string, actor *Actor) {
+func AnswerRequest(followReqBody string, actor *Actor, reject bool) {
 	actorAt := GetActorAt(actor)
-	fmt.Println("Actor:", actorAt)
+	slog.Info("answering follow", "actor", actorAt, "reject", reject)
 
-	payload := fmt.Sprintf(`{
-		"@context": "https://www.w3.org/ns/activitystreams",
-		"id": "%s/ap/user/max#accepts/follows/%s",
-		"type": "Accept",
-		"actor": "%s/ap/user/max",
-		"object": %s%s`, hostSite, actorAt, hostSite, followReqBody, "\n}\n")
+	var followObj map[string]any
+	err := json.Unmarshal([]byte(followReqBody), &followObj)
+	if err != nil {
+		slog.Error("could not decode follow request", "err", err)
+		return
+	}
+	delete(followObj, "@context")
+
+	// the inbox has already checked the follow is for one of ours
+	followed := LocalActorByID(GetLinkOrObjectID(followObj["object"]))
+	if followed == nil {
+		followed = DefaultActor()
+	}
+	answer := NewAccept(followed, followObj, actor)
+	if reject {
+		answer = NewReject(followed, followObj, actor)
+	}
+	payload, err := answer.Payload()
+	if err != nil {
+		slog.Error("could not encode answer", "type", answer.Type, "err", err)
+		return
+	}
+
+	err = SendActivity(payload, actor)
+	if err != nil {
+		slog.Error("could not send answer", "type", answer.Type, "err", err)
+		return
+	}
 
-	err := SendActivity(payload, actor)
+	// keep a copy so the answer's ID can be dereferenced
+	err = kv.SaveActivity(answer, payload)
 	if err != nil {
-		fmt.Println("error sending activity:", err.Error())
+		slog.Warn("could not save activity", "err", err)
 	}
 }
@@ -3,14 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
 	"google.golang.org/api/iterator"
 )
@@ -20,10 +23,26 @@ func main() {
 }
 
 func handleFollowers(request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	// /ap/followers is the default actor's
+	actor := ap.DefaultActor()
+	if username := request.QueryStringParameters["actor"]; username != "" {
+		actor = ap.FindLocalActor(username)
+		if actor == nil {
+			return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+		}
+	}
+	if config.Site.Features.AuthorizedFetch {
+		_, err := ap.VerifyFetch(&request, "actor")
+		if err != nil {
+			return GetLambdaResp(err)
+		}
+	}
+
 	ctx := context.Background()
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
-		fmt.Println("could not start firestore client:", err)
+		slog.Error("could not start firestore client", "err", err)
 		return &events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
 			Body:       err.Error(),
@@ -33,7 +52,7 @@ func handleFollowers(request LambdaRequest) (*LambdaResponse, error) {
 
 	var followers []string
 
-	iter := client.Collection("followers").Documents(ctx)
+	iter := client.Collection(actor.FollowersCollection()).Documents(ctx)
 	defer iter.Stop()
 	for {
 		doc, err := iter.Next()
@@ -41,7 +60,7 @@ func handleFollowers(request LambdaRequest) (*LambdaResponse, error) {
 			break
 		}
 		if err != nil {
-			fmt.Println("could not call iter next on collection:", err)
+			slog.Error("could not call iter next on collection", "err", err)
 			return &events.APIGatewayProxyResponse{
 				StatusCode: http.StatusInternalServerError,
 				Body:       err.Error(),
@@ -50,15 +69,14 @@ func handleFollowers(request LambdaRequest) (*LambdaResponse, error) {
 		followers = append(followers, doc.Data()["Id"].(string))
 	}
 
-	fmt.Printf("%v\n", followers)
-	host := GetHostSite()
+	slog.Debug("listing followers", "count", len(followers))
 
 	payloadStr := strings.Builder{}
 	payloadStr.WriteString(`{
 	"@context": "https://www.w3.org/ns/activitystreams",
 	"id": "`)
-	payloadStr.WriteString(host)
-	payloadStr.WriteString(`/ap/followers",
+	payloadStr.WriteString(actor.FollowersID())
+	payloadStr.WriteString(`",
 	"type": "OrderedCollection",
 	"totalItems": `)
 	payloadStr.WriteString(strconv.Itoa(len(followers)))
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 
@@ -12,27 +13,51 @@ import (
 
 	"github.com/maxbanister/blog/netlify/ap"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 )
 
-func HandleFollow(actor *ap.Actor, reqJSON map[string]any) error {
+// Records the follower, and reports whether the follow should be accepted
+func HandleFollow(actor *ap.Actor, reqJSON map[string]any) (bool, error) {
+	followed, err := followedActor(reqJSON)
+	if err != nil {
+		return false, err
+	}
+	// new followers should follow where the actor went, and the Reject tells
+	// their server so rather than leaving the follow pending
+	if followed.MovedTo != "" {
+		slog.Info("rejecting follow of moved actor", "actor", followed.ID(),
+			"movedTo", followed.MovedTo)
+		return false, nil
+	}
+
 	ctx := context.Background()
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
-		return fmt.Errorf("could not start firestore client: %w", err)
+		return false, fmt.Errorf("could not start firestore client: %w", err)
 	}
 	defer client.Close()
 
 	// write to json database
 	actorAt := ap.GetActorAt(actor)
-	_, err = client.Collection("followers").Doc(actorAt).Set(ctx, actor)
+	_, err = client.Collection(followed.FollowersCollection()).Doc(actorAt).
+		Set(ctx, actor)
 	if err != nil {
-		return fmt.Errorf("failed adding follower: %v", err)
+		return false, fmt.Errorf("failed adding follower: %v", err)
 	}
 
-	return nil
+	return true, nil
 }
 
 func HandleUnfollow(actor *ap.Actor, requestJSON map[string]any) error {
+	followed := ap.DefaultActor()
+	if follow, ok := requestJSON["object"].(map[string]any); ok {
+		var err error
+		followed, err = followedActor(follow)
+		if err != nil {
+			return err
+		}
+	}
+
 	ctx := context.Background()
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
@@ -42,7 +67,8 @@ func HandleUnfollow(actor *ap.Actor, requestJSON map[string]any) error {
 
 	// write to json database
 	actorAt := ap.GetActorAt(actor)
-	_, err = client.Collection("followers").Doc(actorAt).Delete(ctx)
+	_, err = client.Collection(followed.FollowersCollection()).Doc(actorAt).
+		Delete(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to remove follower: %v", err)
 	}
@@ -50,8 +76,21 @@ func HandleUnfollow(actor *ap.Actor, requestJSON map[string]any) error {
 	return nil
 }
 
-// Invokes the serverless function to send an AcceptFollow to the actor's inbox
-func CallFollowService(r *LambdaRequest, host string, actor *ap.Actor) error {
+// Finds which of our actors a Follow is for
+func followedActor(follow map[string]any) (*ap.LocalActor, error) {
+	objectID := ap.GetLinkOrObjectID(follow["object"])
+	followed := ap.LocalActorByID(objectID)
+	if followed == nil {
+		return nil, fmt.Errorf("%w: %s is not an actor here", ErrBadRequest,
+			objectID)
+	}
+	return followed, nil
+}
+
+// Invokes the serverless function to send an Accept, or a Reject, for the
+// follow to the actor's inbox
+func CallFollowService(r *LambdaRequest, host string, actor *ap.Actor,
+	reject bool) error {
 	actorBytes, err := json.Marshal(actor)
 	if err != nil {
 		return fmt.Errorf("%w: could not encode actor string: %w",
@@ -60,6 +99,7 @@ func CallFollowService(r *LambdaRequest, host string, actor *ap.Actor) error {
 	followReq := ap.FollowServiceRequest{
 		FollowObj: r.Body,
 		Actor:     actorBytes,
+		Reject:    reject,
 	}
 	reqBody, err := json.Marshal(followReq)
 	if err != nil {
@@ -74,19 +114,17 @@ func CallFollowService(r *LambdaRequest, host string, actor *ap.Actor) error {
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	req.Header.Set("Authorization", os.Getenv("SELF_API_KEY"))
+	req.Header.Set(logging.RequestIDHeader, logging.RequestID())
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("could not send post to follow service: %w", err)
 	}
-	fmt.Println("Resp:", resp, "Err:", err)
-
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("follow service wrapper aborted with status: %s",
 			resp.Status)
 	}
-
-	fmt.Println("Returning from follow")
+	slog.Info("handed follow to follow service", "actor", actor.Id)
 
 	return nil
 }
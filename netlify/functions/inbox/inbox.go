@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
 )
 
@@ -16,30 +19,38 @@ func main() {
 }
 
 func handleInbox(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
 	HOST_SITE := GetHostSite()
-	fmt.Println("Headers:", request.Headers)
-	fmt.Println("Body:", request.Body)
+	slog.Debug("got activity", "headers", request.Headers, "body", request.Body)
 
-	requestJSON := make(map[string]any)
-	err := json.Unmarshal([]byte(request.Body), &requestJSON)
+	// normalize prefixed and extension terms before anything reads them
+	requestJSON, err := ap.CompactJSON([]byte(request.Body))
 	if err != nil {
 		return GetLambdaResp(fmt.Errorf(
 			"%w: bad json syntax: %s", ErrBadRequest, err.Error()))
 	}
 	actor, err := ap.RecvActivity(&request, requestJSON)
 	if err != nil {
-		return nil, err
+		return GetLambdaResp(err)
+	}
+	// the digest has been checked, so handlers can decode the compacted form
+	compactedBody, err := json.Marshal(requestJSON)
+	if err != nil {
+		return GetLambdaResp(fmt.Errorf("could not encode activity: %w", err))
 	}
+	request.Body = string(compactedBody)
 
-	fmt.Println("Request type:", requestJSON["type"])
+	slog.Info("received activity", "type", requestJSON["type"],
+		"id", requestJSON["id"], "actor", actor.Id)
 
 	switch requestJSON["type"] {
 	case "Follow":
-		err := HandleFollow(actor, requestJSON)
+		accepted, err := HandleFollow(actor, requestJSON)
 		if err != nil {
 			return GetLambdaResp(err)
 		}
-		return GetLambdaResp(CallFollowService(&request, HOST_SITE, actor))
+		return GetLambdaResp(CallFollowService(&request, HOST_SITE, actor,
+			!accepted))
 
 	case "Create":
 		err := HandleReply(&request, actor, requestJSON, HOST_SITE)
@@ -70,13 +81,16 @@ func handleInbox(ctx context.Context, request LambdaRequest) (*LambdaResponse, e
 		var err error
 		if object["type"] == "Person" {
 			err = HandleProfileUpdate(&request, requestJSON)
-		} else if object["type"] == "Note" {
-			err = HandleReplyEdit(&request, requestJSON)
+		} else if typ, _ := object["type"].(string); slices.Contains(editableTypes, typ) {
+			err = HandleReplyEdit(actor, requestJSON)
 		} else {
 			break
 		}
 		return GetLambdaResp(err)
 
+	case "Move":
+		return GetLambdaResp(HandleMove(actor, requestJSON))
+
 	case "Like":
 		return GetLambdaResp(HandleLike(actor, requestJSON, HOST_SITE))
 
@@ -85,7 +99,7 @@ func handleInbox(ctx context.Context, request LambdaRequest) (*LambdaResponse, e
 
 	case "Accept":
 		object, _ := requestJSON["object"].(map[string]any)
-		fmt.Println("Got AcceptFollow from", requestJSON["actor"])
+		slog.Info("got accept", "actor", requestJSON["actor"])
 		if object["type"] == "Follow" {
 			return GetLambdaResp(nil)
 		} else {
@@ -3,11 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 
-	"cloud.google.com/go/firestore"
 	"github.com/maxbanister/blog/netlify/ap"
 	"github.com/maxbanister/blog/netlify/kv"
 	. "github.com/maxbanister/blog/netlify/util"
@@ -38,17 +38,6 @@ func endorse(a *ap.Actor, reqJSON map[string]any, colName, host string) error {
 	if err != nil {
 		return fmt.Errorf("%w: malformed object URI: %w", ErrBadRequest, err)
 	}
-	slugObject := Sluggify(*objectURI)
-
-	endorseBackLink, _ := reqJSON["url"].(string)
-
-	// this is the id of the like/share activity
-	endorseURIString, _ := reqJSON["id"].(string)
-	endorseURI, err := url.Parse(endorseURIString)
-	if err != nil {
-		return fmt.Errorf("%w: malformed ID URI: %w", ErrBadRequest, err)
-	}
-	slugEndorse := Sluggify(*endorseURI)
 
 	// open database connection to firestore
 	ctx := context.Background()
@@ -58,12 +47,10 @@ func endorse(a *ap.Actor, reqJSON map[string]any, colName, host string) error {
 	}
 	defer client.Close()
 
-	collectionRef := client.Collection(colName)
-	objectDocRef := collectionRef.Doc(slugObject)
-	endorseDocRef := collectionRef.Doc(slugEndorse)
+	objectDocRef := client.Collection(colName).Doc(Sluggify(*objectURI))
 
 	// check if post exists
-	fmt.Println("Checking for", objectURIString)
+	slog.Debug("checking for post", "id", objectURIString)
 	_, err = objectDocRef.Get(ctx)
 	if err != nil {
 		if status.Code(err) != codes.NotFound {
@@ -79,42 +66,23 @@ func endorse(a *ap.Actor, reqJSON map[string]any, colName, host string) error {
 			return fmt.Errorf("%w: referenced post nonexistent", ErrBadRequest)
 		}
 	}
-	fmt.Println("Post", objectURIString, "found")
-
-	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
-		// add to object's list of likes/shares
-		err = tx.Set(objectDocRef, map[string]any{
-			"Id":    objectURI.JoinPath(colName).String(),
-			"Items": firestore.ArrayUnion(endorseURIString),
-		}, firestore.MergeAll)
-		if err != nil {
-			return fmt.Errorf("failed to add item: %v", err)
-		}
+	slog.Debug("post found", "id", objectURIString)
 
-		// create like/share activity
-		err = tx.Set(endorseDocRef, map[string]any{
-			"Id":     endorseURIString,
-			"URL":    endorseBackLink,
-			"Object": objectURIString,
-			"Actor":  a,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to add item: %v", err)
-		}
-		return nil
-	}
-
-	return client.RunTransaction(ctx, txFunc)
+	endorseBackLink, _ := reqJSON["url"].(string)
+	// this is the id of the like/share activity
+	endorseID, _ := reqJSON["id"].(string)
+
+	return kv.SaveEndorsement(ctx, client, colName, &ap.LikeOrShare{
+		Id:     endorseID,
+		URL:    endorseBackLink,
+		Object: objectURIString,
+		Actor:  a,
+	})
 }
 
 func unendorse(reqJSON map[string]any, colName string) error {
 	// object in this context is the original post being liked/shared
 	objectID := ap.GetLinkOrObjectID(reqJSON["object"])
-	objectIDURI, err := url.Parse(objectID)
-	if err != nil {
-		return fmt.Errorf("%w: malformed ID URI: %w", ErrBadRequest, err)
-	}
-	slugObjID := Sluggify(*objectIDURI)
 
 	// open database connection to firestore
 	ctx := context.Background()
@@ -124,43 +92,5 @@ func unendorse(reqJSON map[string]any, colName string) error {
 	}
 	defer client.Close()
 
-	collectionRef := client.Collection(colName)
-	likeOrShareDocRef := collectionRef.Doc(slugObjID)
-
-	// Need to get the ID of the post this like/share refers to from firestore
-	fmt.Printf("Getting %s/%s\n", colName, slugObjID)
-	likeOrShareDoc, err := likeOrShareDocRef.Get(ctx)
-	if err != nil {
-		return fmt.Errorf("error looking up document: %w", err)
-	}
-	originalPostURI, err := likeOrShareDoc.DataAt("Object")
-	originalPostURIStr, _ := originalPostURI.(string)
-	if originalPostURIStr == "" || err != nil {
-		return fmt.Errorf("error getting document data: %w", err)
-	}
-
-	opURI, err := url.Parse(originalPostURIStr)
-	if err != nil {
-		return fmt.Errorf("%w: malformed object URI: %w", ErrBadRequest, err)
-	}
-	slugOPURI := Sluggify(*opURI)
-	originalPostDocRef := collectionRef.Doc(slugOPURI)
-
-	fmt.Printf("Attempting to remove %s/%s\n", colName, slugObjID)
-
-	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
-		err = tx.Delete(likeOrShareDocRef)
-		if err != nil {
-			return fmt.Errorf("failed to get item: %w", err)
-		}
-		err = tx.Update(originalPostDocRef, []firestore.Update{
-			{Path: "Items", Value: firestore.ArrayRemove(objectID)},
-		})
-		if err != nil {
-			return fmt.Errorf("failed to remove item: %w", err)
-		}
-		return nil
-	}
-
-	return client.RunTransaction(ctx, txFunc)
+	return kv.DeleteEndorsement(ctx, client, colName, objectID, "")
 }
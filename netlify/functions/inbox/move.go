@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// An account moving to one of our actors. Its followers' servers then follow
+// our actor on their behalf, which is only allowed from accounts the actor
+// lists as its own in alsoKnownAs; remote servers check that against our
+// actor document, so here it's confirmed and logged.
+func HandleMove(actor *ap.Actor, reqJSON map[string]any) error {
+	origin := ap.GetLinkOrObjectID(reqJSON["object"])
+	targetID := ap.GetLinkOrObjectID(reqJSON["target"])
+	if origin != actor.Id {
+		return fmt.Errorf("%w: actor must be equal to object id", ErrBadRequest)
+	}
+	target := ap.LocalActorByID(targetID)
+	if target == nil {
+		return fmt.Errorf("%w: only moves to our actors are handled",
+			ErrNotImplemented)
+	}
+	if !slices.Contains(target.AlsoKnownAs, origin) {
+		return fmt.Errorf("%w: %s is not in the alsoKnownAs of %s",
+			ErrBadRequest, origin, targetID)
+	}
+	slog.Info("accepting followers moving", "from", origin, "to", targetID)
+	return nil
+}
@@ -2,116 +2,250 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
 	"github.com/maxbanister/blog/netlify/kv"
 	. "github.com/maxbanister/blog/netlify/util"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// How far up a thread we'll walk looking for the post a reply belongs to
+const maxAncestorDepth = 10
+
+// How many pages of a FEP-7888 context collection we'll read
+const maxContextPages = 3
+
 func HandleReply(r *LambdaRequest, actor *ap.Actor, reqJSON map[string]any, host string) error {
-	var c struct {
-		Object ap.Reply `json:"object"`
+	object, ok := reqJSON["object"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: reply object not embedded", ErrBadRequest)
+	}
+	replyObj, err := parseReply(object, actor)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return fmt.Errorf("could not start firestore client: %w", err)
 	}
-	err := json.Unmarshal([]byte(r.Body), &c)
+	defer client.Close()
+
+	// A reply to a reply we never received can still belong under one of our
+	// posts, so fetch whatever is missing between it and the post
+	ancestors, err := fetchMissingAncestors(ctx, client, replyObj, object, host)
 	if err != nil {
 		return err
 	}
-	replyObj := c.Object
+	for _, ancestor := range ancestors {
+		slog.Info("backfilling", "id", ancestor.Id)
+		err := kv.SaveReply(ctx, client, ancestor)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("could not store ancestor %s: %w", ancestor.Id, err)
+		}
+	}
+
+	return kv.SaveReply(ctx, client, replyObj)
+}
+
+// Validates a reply object and readies it to be stored
+func parseReply(object map[string]any, actor *ap.Actor) (*ap.Reply, error) {
+	replyObj, err := ap.DecodeReply(object)
+	if err != nil {
+		return nil, err
+	}
+	replyObj.RawContent = replyObj.Content
+	replyObj.Content = ap.SanitizeHTML(replyObj.Content)
 
 	// validate reply properties
 	inReplyTo := ap.GetLinkOrObjectID(replyObj.InReplyTo)
 	if inReplyTo == "" {
-		return fmt.Errorf("%w: inReplyTo not provided", ErrBadRequest)
+		return nil, fmt.Errorf("%w: inReplyTo not provided", ErrBadRequest)
 	}
 	replyObj.InReplyTo = inReplyTo
 	_, err = time.Parse(time.RFC3339, replyObj.Published)
 	if err != nil {
-		return fmt.Errorf("%w: bad published timestamp: %w", ErrBadRequest, err)
+		return nil, fmt.Errorf("%w: bad published timestamp: %w", ErrBadRequest, err)
 	}
 	_, err = url.Parse(replyObj.URL)
 	if err != nil {
-		return fmt.Errorf("%w: malformed backlink URL: %w", ErrBadRequest, err)
+		return nil, fmt.Errorf("%w: malformed backlink URL: %w", ErrBadRequest, err)
 	}
 	if replyObj.AttributedTo != actor.Id {
-		return fmt.Errorf("%w: actor and attributedTo mismatch", ErrBadRequest)
+		return nil, fmt.Errorf("%w: actor and attributedTo mismatch", ErrBadRequest)
 	}
-
-	inReplyToURI, err := url.Parse(inReplyTo)
+	_, err = url.Parse(inReplyTo)
 	if err != nil {
-		return fmt.Errorf("%w: malformed inReplyTo URI: %w", ErrBadRequest, err)
+		return nil, fmt.Errorf("%w: malformed inReplyTo URI: %w", ErrBadRequest, err)
 	}
-	inReplyToSlug := Sluggify(*inReplyToURI)
 	if replyObj.Id == "" || replyObj.Content == "" {
-		return fmt.Errorf("%w: missing reply details", ErrBadRequest)
+		return nil, fmt.Errorf("%w: missing reply details", ErrBadRequest)
 	}
-	replyObjId, err := url.Parse(replyObj.Id)
+	_, err = url.Parse(replyObj.Id)
 	if err != nil {
-		return fmt.Errorf("%w: malformed object id: %w", ErrBadRequest, err)
+		return nil, fmt.Errorf("%w: malformed object id: %w", ErrBadRequest, err)
 	}
-	replyIdSlug := Sluggify(*replyObjId)
 
 	replyObj.Actor = actor
+	return replyObj, nil
+}
 
-	ctx := context.Background()
-	client, err := kv.GetFirestoreClient()
-	if err != nil {
-		return fmt.Errorf("could not start firestore client: %w", err)
-	}
-	defer client.Close()
+// Returns the replies missing between reply and the stored reply or post of
+// ours it ultimately answers, topmost first. It's an error if the thread
+// doesn't lead back to one of our posts within maxAncestorDepth, or at all
+// when backfilling is turned off.
+func fetchMissingAncestors(ctx context.Context, client *firestore.Client, reply *ap.Reply, object map[string]any, host string) ([]*ap.Reply, error) {
+	var cached map[string]map[string]any
+	var missing []*ap.Reply
+	parentID := reply.InReplyTo.(string)
+	for depth := 0; ; depth++ {
+		known, err := isKnownParent(ctx, client, parentID, host)
+		if err != nil {
+			return nil, err
+		}
+		if known {
+			slices.Reverse(missing)
+			return missing, nil
+		}
+		if !config.Site.Features.ReplyBackfill {
+			return nil, fmt.Errorf("%w: parent %s not stored", ErrBadRequest, parentID)
+		}
+		if depth == maxAncestorDepth {
+			return nil, fmt.Errorf("%w: no post of ours within %d replies",
+				ErrBadRequest, maxAncestorDepth)
+		}
 
-	fmt.Println("Checking for", inReplyTo)
-	repliesCollection := client.Collection("replies")
-	// check if inReplyTo's object exists in the replies collection
-	_, err = repliesCollection.Doc(inReplyToSlug).Get(ctx)
-	if err != nil {
-		if status.Code(err) != codes.NotFound {
-			return fmt.Errorf("error looking up replies: %w", err)
+		if cached == nil {
+			// only worth reading once we know something is missing
+			cached = contextObjects(object)
 		}
-		// this post isn't in the replies collection yet - confirm post exists
-		_, host, _ := strings.Cut(host, "//")
-		if host != inReplyToURI.Host {
-			return fmt.Errorf("%w: reply not for this domain", ErrBadRequest)
+		parentObj, ok := cached[parentID]
+		if !ok {
+			slog.Info("fetching missing parent", "id", parentID)
+			parentObj, err = ap.GetObject(parentID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: could not fetch parent %s: %w",
+					ErrBadRequest, parentID, err)
+			}
 		}
-		resp, err := http.Head(inReplyTo)
-		if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("%w: referenced post nonexistent", ErrBadRequest)
+		parent, err := parseAncestor(parentObj, parentID)
+		if err != nil {
+			return nil, err
 		}
+		missing = append(missing, parent)
+		parentID = parent.InReplyTo.(string)
 	}
-	fmt.Println("Post", inReplyTo, "found")
+}
 
-	// We need to write two documents: the reply being added, and the original
-	// post (which may not exist yet) to link it to the newly created reply.
+// Checks that a fetched ancestor is what it claims to be before parsing it
+// like any other reply
+func parseAncestor(object map[string]any, id string) (*ap.Reply, error) {
+	if ap.GetLinkOrObjectID(object["id"]) != id {
+		return nil, fmt.Errorf("%w: fetched parent has id %v, not %s",
+			ErrBadRequest, object["id"], id)
+	}
+	if ap.GetLinkOrObjectID(object["inReplyTo"]) == "" {
+		// the thread started somewhere other than our posts
+		return nil, fmt.Errorf("%w: reply not for this domain", ErrBadRequest)
+	}
+	attributedTo := ap.GetLinkOrObjectID(object["attributedTo"])
+	idURI, _ := url.Parse(id)
+	authorURI, err := url.Parse(attributedTo)
+	if err != nil || attributedTo == "" || authorURI.Host != idURI.Host {
+		return nil, fmt.Errorf("%w: parent %s not from its author's server",
+			ErrBadRequest, id)
+	}
+	actor, err := ap.FetchActorAuthorized(attributedTo)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch author of %s: %w", id, err)
+	}
+	return parseReply(object, actor)
+}
 
-	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
-		// this will fail if the reply ID already exists
-		newReplyDoc := repliesCollection.Doc(replyIdSlug)
-		if err := tx.Create(newReplyDoc, replyObj); err != nil {
-			return err
-		}
+// A parent is known if we've stored it, or it's one of our posts
+func isKnownParent(ctx context.Context, client *firestore.Client, parentID, host string) (bool, error) {
+	slog.Debug("checking for parent", "id", parentID)
+	parentURI, err := url.Parse(parentID)
+	if err != nil {
+		return false, fmt.Errorf("%w: malformed inReplyTo URI: %w", ErrBadRequest, err)
+	}
+	// check if inReplyTo's object exists in the replies collection
+	_, err = client.Collection("replies").Doc(Sluggify(*parentURI)).Get(ctx)
+	if err == nil {
+		return true, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return false, fmt.Errorf("error looking up replies: %w", err)
+	}
+	// this post isn't in the replies collection yet - confirm post exists
+	_, host, _ = strings.Cut(host, "//")
+	if host != parentURI.Host {
+		return false, nil
+	}
+	resp, err := http.Head(parentID)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%w: referenced post nonexistent", ErrBadRequest)
+	}
+	slog.Debug("parent found", "id", parentID)
+	return true, nil
+}
 
-		// If it's the first comment to a top-level post, we will create a new
-		// reply document for it. Otherwise, we will just merge the reply sets.
-		// For replies-to-replies, the parent reply will already exist.
-		return tx.Set(repliesCollection.Doc(inReplyToSlug), map[string]any{
-			"Id": inReplyTo,
-			"Replies": map[string]any{ // will clobber other fields in struct
-				"Id":    inReplyToURI.JoinPath("replies").String(),
-				"Items": firestore.ArrayUnion(replyObj.Id),
-			},
-		}, firestore.MergeAll)
-	}
-	if err = client.RunTransaction(ctx, txFunc); err != nil {
-		return err
+// Reads the objects in the conversation a reply offers as its context
+// (FEP-7888), keyed by ID. Only objects hosted alongside the collection are
+// kept, as the collection can't vouch for anyone else's.
+func contextObjects(object map[string]any) map[string]map[string]any {
+	objects := make(map[string]map[string]any)
+	contextID := ap.GetLinkOrObjectID(object["context"])
+	contextURI, err := url.Parse(contextID)
+	if contextID == "" || err != nil ||
+		(contextURI.Scheme != "https" && contextURI.Scheme != "http") {
+		// often just an opaque conversation identifier
+		return objects
+	}
+
+	var page any = contextID
+	for i := 0; i < maxContextPages && page != nil; i++ {
+		pageObj, err := ap.GetObject(page)
+		if err != nil {
+			slog.Warn("could not fetch context collection", "err", err)
+			break
+		}
+		for _, key := range []string{"orderedItems", "items"} {
+			items, _ := pageObj[key].([]any)
+			for _, item := range items {
+				itemObj, ok := item.(map[string]any)
+				if !ok {
+					continue
+				}
+				// some collections list the Create activities instead
+				if inner, ok := itemObj["object"].(map[string]any); ok &&
+					itemObj["type"] == "Create" {
+					itemObj = inner
+				}
+				id := ap.GetLinkOrObjectID(itemObj["id"])
+				idURI, err := url.Parse(id)
+				if id != "" && err == nil && idURI.Host == contextURI.Host {
+					objects[id] = itemObj
+				}
+			}
+		}
+		if first, ok := pageObj["first"]; ok && i == 0 {
+			page = first
+		} else {
+			page = pageObj["next"]
+		}
 	}
 
-	return nil
+	return objects
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/url"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/maxbanister/blog/netlify/ap"
@@ -38,18 +40,34 @@ func HandleProfileUpdate(r *LambdaRequest, reqJSON map[string]any) error {
 	return kv.UpdateAllActorRefs(&actor)
 }
 
-func HandleReplyEdit(r *LambdaRequest, reqJSON map[string]any) error {
+// Objects that can be replies, and so can be edited
+var editableTypes = []string{"Note", "Question", "Article", "Page"}
+
+func HandleReplyEdit(actor *ap.Actor, reqJSON map[string]any) error {
 	editedObj, _ := reqJSON["object"].(map[string]any)
 	id, _ := editedObj["id"].(string)
 	if id == "" {
-		fmt.Println("%w: malformed update object", ErrBadRequest)
+		return fmt.Errorf("%w: malformed update object", ErrBadRequest)
 	}
 	replyURI, err := url.Parse(id)
 	if err != nil {
-		fmt.Println("%w: unable to parse object id URI", ErrBadRequest)
+		return fmt.Errorf("%w: unable to parse object id URI", ErrBadRequest)
 	}
 	slugReplyID := Sluggify(*replyURI)
-	fmt.Println("Attempting edit of", slugReplyID)
+	slog.Info("attempting edit", "reply", slugReplyID)
+
+	edited, err := ap.DecodeReply(editedObj)
+	if err != nil {
+		return err
+	}
+	if edited.Content == "" {
+		return fmt.Errorf("%w: must provide update content", ErrBadRequest)
+	}
+	editTime, err := time.Parse(time.RFC3339, edited.Updated)
+	if err != nil {
+		return fmt.Errorf("%w: bad or missing \"updated\" time: %w",
+			ErrBadRequest, err)
+	}
 
 	// fetch note object from firestore
 	client, err := kv.GetFirestoreClient()
@@ -77,24 +95,46 @@ func HandleReplyEdit(r *LambdaRequest, reqJSON map[string]any) error {
 		}
 
 		// validate edit object
-		editDate, ok := editedObj["updated"].(string)
-		if !ok {
-			return fmt.Errorf("%w: no \"updated\" time provided", ErrBadRequest)
+		if storedReply.AttributedTo != actor.Id {
+			return fmt.Errorf("%w: only the author can edit a reply",
+				ErrUnauthorized)
+		}
+		storedDate := storedReply.Updated
+		if storedDate == "" {
+			storedDate = storedReply.Published
+		}
+		// compare as times, since servers format them in different zones
+		storedTime, err := time.Parse(time.RFC3339, storedDate)
+		if err == nil && editTime.Equal(storedTime) {
+			return fmt.Errorf("%w: edit already applied", ErrAlreadyDone)
 		}
-		if editDate < storedReply.Updated {
+		if err == nil && editTime.Before(storedTime) {
 			return fmt.Errorf("%w: provided object predates existing object",
 				ErrBadRequest)
 		}
-		editedContent, _ := editedObj["content"].(string)
-		if editedContent == "" {
-			return fmt.Errorf("%w: must provide update content", ErrBadRequest)
-		}
 
-		// update stored object
+		// keep the version being replaced
+		history := append(storedReply.History, ap.Revision{
+			Updated:    storedDate,
+			Content:    storedReply.Content,
+			RawContent: storedReply.RawContent,
+			Summary:    storedReply.Summary,
+			Sensitive:  storedReply.Sensitive,
+			Attachment: storedReply.Attachment,
+		})
+
+		// update stored object, including attachments and content warnings
+		// which may have been added or removed
 		err = tx.Update(docRef, []firestore.Update{
-			{Path: "Updated", Value: editDate},
-			{Path: "URL", Value: editedObj["url"]},
-			{Path: "Content", Value: editedContent},
+			{Path: "Updated", Value: edited.Updated},
+			{Path: "URL", Value: edited.URL},
+			{Path: "Content", Value: ap.SanitizeHTML(edited.Content)},
+			{Path: "RawContent", Value: edited.Content},
+			{Path: "Summary", Value: edited.Summary},
+			{Path: "Sensitive", Value: edited.Sensitive},
+			{Path: "Attachment", Value: edited.Attachment},
+			{Path: "Tag", Value: edited.Tag},
+			{Path: "History", Value: history},
 		})
 		if err != nil {
 			return fmt.Errorf("could not update reply doc: %w", err)
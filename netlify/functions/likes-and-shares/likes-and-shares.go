@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/maxbanister/blog/netlify/ap"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -22,6 +24,7 @@ func main() {
 }
 
 func handleService(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
 	HOST_SITE := GetHostSite()
 
 	colName := request.QueryStringParameters["col"]
@@ -35,6 +38,16 @@ func handleService(ctx context.Context, request LambdaRequest) (*LambdaResponse,
 }
 
 func FetchCol(r *LambdaRequest, host, colName string) (*LambdaResponse, error) {
+	wantsAP := false
+	a := strings.ToLower(r.Headers["accept"])
+	if strings.Contains(a, "activity+json") || strings.Contains(a, "ld+json") {
+		wantsAP = true
+	}
+	// the site's own pages don't ask for ActivityPub, and needn't sign
+	if err := ap.VerifyCollectionFetch(r, wantsAP); err != nil {
+		return GetLambdaResp(err)
+	}
+
 	// connect to firestore database
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
@@ -47,7 +60,6 @@ func FetchCol(r *LambdaRequest, host, colName string) (*LambdaResponse, error) {
 	// get title from query param
 	postID := r.QueryStringParameters["id"]
 	postURIString := host + "/posts/" + postID
-	fmt.Println(postURIString)
 
 	// form full sluggified url
 	postURI, err := url.Parse(postURIString)
@@ -55,13 +67,7 @@ func FetchCol(r *LambdaRequest, host, colName string) (*LambdaResponse, error) {
 		return nil, fmt.Errorf("could not parse as URI: %w", err)
 	}
 	slugPostURI := Sluggify(*postURI)
-	fmt.Println("Got request for", slugPostURI)
-
-	wantsAP := false
-	a := strings.ToLower(r.Headers["accept"])
-	if strings.Contains(a, "activity+json") || strings.Contains(a, "ld+json") {
-		wantsAP = true
-	}
+	slog.Info("got request", "post", slugPostURI)
 
 	// get top-level likes document from firestore
 	collectionRef := client.Collection(colName)
@@ -93,11 +99,11 @@ func FetchCol(r *LambdaRequest, host, colName string) (*LambdaResponse, error) {
 		}
 		parsedURI, err := url.Parse(uriString)
 		if err != nil {
-			fmt.Println("could not parse URI", uri)
+			slog.Warn("could not parse URI", "uri", uri)
 			continue
 		}
 		docTitle := Sluggify(*parsedURI)
-		fmt.Println("adding", docTitle)
+		slog.Debug("adding", "doc", docTitle)
 		docRefs = append(docRefs, collectionRef.Doc(docTitle))
 	}
 
@@ -111,10 +117,9 @@ func FetchCol(r *LambdaRequest, host, colName string) (*LambdaResponse, error) {
 		likeOrShare := &ap.LikeOrShare{}
 		err := doc.DataTo(&likeOrShare)
 		if err != nil {
-			fmt.Println("could not convert activity doc to struct:", err)
+			slog.Warn("could not convert activity doc to struct", "err", err)
 			continue
 		}
-		fmt.Println(likeOrShare)
 		likesOrShares = append(likesOrShares, likeOrShare)
 	}
 
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestorepb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	blog "github.com/maxbanister/blog"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
+	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+const schemaURL = "http://nodeinfo.diaspora.software/ns/schema/2.1"
+
+// How long a generated document is served before the counts are redone
+const cacheTTL = time.Hour
+
+// Kept between invocations of a warm function
+var cache struct {
+	sync.Mutex
+	body    string
+	expires time.Time
+}
+
+type NodeInfo struct {
+	Version           string         `json:"version"`
+	Software          Software       `json:"software"`
+	Protocols         []string       `json:"protocols"`
+	Services          Services       `json:"services"`
+	OpenRegistrations bool           `json:"openRegistrations"`
+	Usage             Usage          `json:"usage"`
+	Metadata          map[string]any `json:"metadata"`
+}
+
+type Software struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"`
+	Homepage   string `json:"homepage,omitempty"`
+}
+
+type Services struct {
+	Inbound  []string `json:"inbound"`
+	Outbound []string `json:"outbound"`
+}
+
+type Usage struct {
+	Users struct {
+		Total          int `json:"total"`
+		ActiveHalfyear int `json:"activeHalfyear"`
+		ActiveMonth    int `json:"activeMonth"`
+	} `json:"users"`
+	LocalPosts int `json:"localPosts"`
+}
+
+func main() {
+	lambda.Start(handleNodeInfo)
+}
+
+// Serves the NodeInfo discovery document at /.well-known/nodeinfo, and the
+// NodeInfo 2.1 document it points to
+func handleNodeInfo(request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	host := GetHostSite()
+	if request.QueryStringParameters["doc"] == "discovery" {
+		body, _ := json.Marshal(map[string]any{
+			"links": []map[string]string{{
+				"rel":  schemaURL,
+				"href": host + "/nodeinfo/2.1",
+			}},
+		})
+		return nodeInfoResp(string(body), "application/json")
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+	if time.Now().Before(cache.expires) {
+		return nodeInfoResp(cache.body, nodeInfoContentType())
+	}
+
+	nodeInfo, err := buildNodeInfo(host)
+	if err != nil {
+		return GetErrorResp(err)
+	}
+	body, err := json.Marshal(nodeInfo)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not marshal nodeinfo: %w", err))
+	}
+	cache.body = string(body)
+	cache.expires = time.Now().Add(cacheTTL)
+	return nodeInfoResp(cache.body, nodeInfoContentType())
+}
+
+func buildNodeInfo(host string) (*NodeInfo, error) {
+	localPosts, lastPost, err := countPosts()
+	if err != nil {
+		return nil, err
+	}
+	lastPublished := ""
+	if !lastPost.IsZero() {
+		lastPublished = lastPost.Format(time.RFC3339)
+	}
+
+	ctx := context.Background()
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+
+	counts := make(map[string]int64)
+	queries := map[string]firestore.Query{
+		// these collections also hold a document per post listing its
+		// replies, likes or shares; only those with an author are counted
+		"replies": client.Collection("replies").Where("AttributedTo", ">", ""),
+		"likes":   client.Collection("likes").Where("Object", ">", ""),
+		"shares":  client.Collection("shares").Where("Object", ">", ""),
+	}
+	for name, query := range queries {
+		count, err := countDocs(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("could not count %s: %w", name, err)
+		}
+		counts[name] = count
+	}
+	for _, actor := range ap.LocalActors {
+		count, err := countDocs(ctx, client.Collection(actor.FollowersCollection()).Query)
+		if err != nil {
+			return nil, fmt.Errorf("could not count followers: %w", err)
+		}
+		counts["followers"] += count
+	}
+
+	nodeInfo := &NodeInfo{
+		Version:   "2.1",
+		Software:  software(),
+		Protocols: []string{"activitypub"},
+		Services: Services{
+			Inbound:  []string{},
+			Outbound: []string{"rss2.0"},
+		},
+		OpenRegistrations: false,
+		Metadata: map[string]any{
+			"nodeName":      config.Site.SiteName,
+			"followers":     counts["followers"],
+			"replies":       counts["replies"],
+			"likes":         counts["likes"],
+			"shares":        counts["shares"],
+			"lastPublished": lastPublished,
+		},
+	}
+	nodeInfo.Usage.LocalPosts = localPosts
+	nodeInfo.Usage.Users.Total = len(ap.LocalActors)
+	// the site counts as active whenever something's posted
+	if time.Since(lastPost) < 30*24*time.Hour {
+		nodeInfo.Usage.Users.ActiveMonth = 1
+	}
+	if time.Since(lastPost) < 180*24*time.Hour {
+		nodeInfo.Usage.Users.ActiveHalfyear = 1
+	}
+	return nodeInfo, nil
+}
+
+// Counts the posts in the embedded outbox, and finds when the latest was
+// published. Edited posts show up as an Update rather than a Create, so
+// both count, once per object.
+func countPosts() (int, time.Time, error) {
+	var outbox struct {
+		OrderedItems []struct {
+			Type   string `json:"type"`
+			Object struct {
+				Id        string `json:"id"`
+				Published string `json:"published"`
+			} `json:"object"`
+		} `json:"orderedItems"`
+	}
+	outboxJSON, err := blog.OutboxJSON()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	err = json.Unmarshal(outboxJSON, &outbox)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("could not decode outbox JSON: %w", err)
+	}
+	posts := make(map[string]bool)
+	var lastPost time.Time
+	for _, item := range outbox.OrderedItems {
+		if item.Type != "Create" && item.Type != "Update" {
+			continue
+		}
+		posts[item.Object.Id] = true
+		published, err := time.Parse(time.RFC3339, item.Object.Published)
+		if err == nil && published.After(lastPost) {
+			lastPost = published
+		}
+	}
+	return len(posts), lastPost, nil
+}
+
+// Has Firestore count the documents rather than reading each one
+func countDocs(ctx context.Context, query firestore.Query) (int64, error) {
+	results, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := results["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", results["count"])
+	}
+	return count.GetIntegerValue(), nil
+}
+
+// Names the software from the site config, and versions it by the commit it was
+// built from
+func software() Software {
+	sw := Software{
+		Name:       config.Site.Software,
+		Version:    "unknown",
+		Repository: config.Site.Repository,
+		Homepage:   GetHostSite() + "/",
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return sw
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		sw.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			sw.Version = setting.Value[:12]
+		}
+	}
+	return sw
+}
+
+func nodeInfoContentType() string {
+	return `application/json; profile="` + schemaURL + `#"`
+}
+
+func nodeInfoResp(body, contentType string) (*LambdaResponse, error) {
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":                contentType,
+			"Cache-Control":               fmt.Sprintf("public, max-age=%d", int(cacheTTL.Seconds())),
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: body,
+	}, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	blog "github.com/maxbanister/blog"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/api/iterator"
+)
+
+const pageSize = 20
+
+// activities sent at runtime show up in the outbox within this long
+const cacheTTL = 5 * time.Minute
+
+// Warm instances keep each actor's merged outbox, rather than reading every
+// stored activity for every page
+var cache struct {
+	sync.Mutex
+	outboxes map[string]cachedOutbox
+}
+
+type cachedOutbox struct {
+	items   []datedItem
+	expires time.Time
+}
+
+func main() {
+	lambda.Start(handleOutbox)
+}
+
+type datedItem struct {
+	date time.Time
+	item map[string]any
+}
+
+func handleOutbox(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	// /ap/outbox is the default actor's
+	actor := ap.DefaultActor()
+	if username := request.QueryStringParameters["actor"]; username != "" {
+		actor = ap.FindLocalActor(username)
+		if actor == nil {
+			return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+		}
+	}
+	outboxID := actor.OutboxID()
+
+	items, err := cachedOutboxItems(ctx, actor)
+	if err != nil {
+		return GetErrorResp(err)
+	}
+
+	lastPage := max(1, (len(items)+pageSize-1)/pageSize)
+	pageParam := request.QueryStringParameters["page"]
+	if pageParam == "" {
+		return collectionResp(map[string]any{
+			"@context":   "https://www.w3.org/ns/activitystreams",
+			"id":         outboxID,
+			"type":       "OrderedCollection",
+			"totalItems": len(items),
+			"first":      outboxID + "?page=1",
+			"last":       outboxID + "?page=" + strconv.Itoa(lastPage),
+		})
+	}
+
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page < 1 || page > lastPage {
+		return GetLambdaResp(fmt.Errorf("%w: invalid page", ErrBadRequest))
+	}
+	start := (page - 1) * pageSize
+	end := min(start+pageSize, len(items))
+	pageItems := make([]map[string]any, 0, end-start)
+	for _, item := range items[start:end] {
+		pageItems = append(pageItems, item.item)
+	}
+
+	collectionPage := map[string]any{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           outboxID + "?page=" + strconv.Itoa(page),
+		"type":         "OrderedCollectionPage",
+		"partOf":       outboxID,
+		"totalItems":   len(items),
+		"orderedItems": pageItems,
+	}
+	if page > 1 {
+		collectionPage["prev"] = outboxID + "?page=" + strconv.Itoa(page-1)
+	}
+	if page < lastPage {
+		collectionPage["next"] = outboxID + "?page=" + strconv.Itoa(page+1)
+	}
+
+	return collectionResp(collectionPage)
+}
+
+func cachedOutboxItems(ctx context.Context, actor *ap.LocalActor) ([]datedItem, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cached, ok := cache.outboxes[actor.Username]; ok &&
+		time.Now().Before(cached.expires) {
+		return cached.items, nil
+	}
+
+	items, err := getOutboxItems(ctx, actor)
+	if err != nil {
+		return nil, err
+	}
+	if cache.outboxes == nil {
+		cache.outboxes = make(map[string]cachedOutbox)
+	}
+	cache.outboxes[actor.Username] = cachedOutbox{items, time.Now().Add(cacheTTL)}
+	return items, nil
+}
+
+// Merges the actor's post activities from the embedded outbox with the
+// public activities it sent at runtime, newest first
+func getOutboxItems(ctx context.Context, actor *ap.LocalActor) ([]datedItem, error) {
+	var outbox struct {
+		OrderedItems []map[string]any `json:"orderedItems"`
+	}
+	outboxJSON, err := blog.OutboxJSON()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(outboxJSON, &outbox)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode outbox JSON: %w", err)
+	}
+	var items []datedItem
+	for _, item := range outbox.OrderedItems {
+		if item["actor"] == actor.ID() {
+			items = append(items, datedItem{getItemDate(item), item})
+		}
+	}
+
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+
+	iter := client.Collection("activities").Where("Public", "==", true).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not call iter next on collection: %w",
+				err)
+		}
+		var stored kv.StoredActivity
+		err = doc.DataTo(&stored)
+		if err != nil {
+			slog.Warn("could not convert doc to struct", "err", err)
+			continue
+		}
+		var item map[string]any
+		err = json.Unmarshal([]byte(stored.Payload), &item)
+		if err != nil {
+			slog.Warn("could not decode stored activity", "err", err)
+			continue
+		}
+		if item["actor"] != actor.ID() {
+			continue
+		}
+		items = append(items, datedItem{getItemDate(item), item})
+	}
+
+	for _, item := range items {
+		// the collection already carries the context
+		delete(item.item, "@context")
+		if object, ok := item.item["object"].(map[string]any); ok {
+			delete(object, "@context")
+		}
+	}
+	slices.SortStableFunc(items, func(a, b datedItem) int {
+		return b.date.Compare(a.date)
+	})
+
+	return items, nil
+}
+
+func getItemDate(item map[string]any) time.Time {
+	dateStr, _ := item["published"].(string)
+	if object, ok := item["object"].(map[string]any); ok {
+		if updated, ok := object["updated"].(string); ok {
+			dateStr = updated
+		}
+	}
+	date, _ := time.Parse(time.RFC3339, dateStr)
+	return date
+}
+
+func collectionResp(collection map[string]any) (*LambdaResponse, error) {
+	body, err := json.Marshal(collection)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not marshal outbox: %w", err))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/activity+json",
+		},
+		Body: string(body),
+	}, nil
+}
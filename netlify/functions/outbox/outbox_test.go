@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func TestGetItemDate(t *testing.T) {
+	created := map[string]any{"published": "2024-01-02T03:04:05Z"}
+	updated := map[string]any{
+		"published": "2024-01-02T03:04:05Z",
+		"object":    map[string]any{"updated": "2024-02-03T04:05:06Z"},
+	}
+	if got := getItemDate(created); !got.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("create dated %v", got)
+	}
+	if got := getItemDate(updated); !got.Equal(time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("update dated %v", got)
+	}
+}
+
+func TestHandleOutboxPages(t *testing.T) {
+	actor := ap.DefaultActor()
+	var items []datedItem
+	for i := range pageSize + 5 {
+		items = append(items, datedItem{item: map[string]any{"id": fmt.Sprint(i)}})
+	}
+	cache.outboxes = map[string]cachedOutbox{
+		actor.Username: {items, time.Now().Add(time.Hour)},
+	}
+	t.Cleanup(func() { cache.outboxes = nil })
+
+	get := func(page string) map[string]any {
+		t.Helper()
+		request := LambdaRequest{QueryStringParameters: map[string]string{}}
+		if page != "" {
+			request.QueryStringParameters["page"] = page
+		}
+		resp, err := handleOutbox(context.Background(), request)
+		if err != nil || resp.StatusCode != 200 {
+			t.Fatalf("page %q: status %d, err %v", page, resp.StatusCode, err)
+		}
+		var body map[string]any
+		json.Unmarshal([]byte(resp.Body), &body)
+		return body
+	}
+
+	outboxID := actor.OutboxID()
+	collection := get("")
+	if collection["totalItems"] != float64(pageSize+5) ||
+		collection["last"] != outboxID+"?page=2" {
+		t.Errorf("collection %v", collection)
+	}
+	first := get("1")
+	if n := len(first["orderedItems"].([]any)); n != pageSize {
+		t.Errorf("first page has %d items", n)
+	}
+	if first["next"] != outboxID+"?page=2" || first["prev"] != nil {
+		t.Errorf("first page links next=%v prev=%v", first["next"], first["prev"])
+	}
+	second := get("2")
+	if n := len(second["orderedItems"].([]any)); n != 5 {
+		t.Errorf("second page has %d items", n)
+	}
+	if second["next"] != nil || second["prev"] != outboxID+"?page=1" {
+		t.Errorf("second page links next=%v prev=%v", second["next"], second["prev"])
+	}
+
+	resp, _ := handleOutbox(context.Background(), LambdaRequest{
+		QueryStringParameters: map[string]string{"page": "3"},
+	})
+	if resp.StatusCode != 400 {
+		t.Errorf("page past the end gave %d", resp.StatusCode)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/subtle"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 
@@ -11,7 +12,10 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/maxbanister/blog/netlify/ap"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func main() {
@@ -19,11 +23,12 @@ func main() {
 }
 
 func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
 	authHdr := []byte(request.Headers["authorization"])
 	selfAPIKey := []byte(os.Getenv("SELF_API_KEY"))
 
 	if subtle.ConstantTimeCompare(authHdr, selfAPIKey) == 0 {
-		fmt.Println("Authorization header did not match key")
+		slog.Warn("authorization header did not match key")
 		return &events.APIGatewayProxyResponse{StatusCode: 400}, nil
 	}
 
@@ -31,6 +36,10 @@ func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error)
 	iconURL := request.QueryStringParameters["iconURL"]
 	colName := request.QueryStringParameters["colName"]
 	refID := request.QueryStringParameters["refID"]
+	if colName == "media" {
+		return findMedia(ctx, iconURL, refID)
+	}
+	slog.Info("refreshing profile", "ref", refID, "icon", iconURL)
 
 	// get old actor
 	client, err := kv.GetFirestoreClient()
@@ -92,3 +101,53 @@ func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error)
 		Body:       iconURL,
 	}, nil
 }
+
+// Answers with the URL if it's an image of the stored reply, so the image
+// proxy only ever passes through media that replies to our posts carry
+func findMedia(ctx context.Context, mediaURL, replyID string) (*LambdaResponse, error) {
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return GetErrorResp(
+			fmt.Errorf("could not start firestore client: %w", err),
+		)
+	}
+	defer client.Close()
+
+	replyURI, err := url.Parse(replyID)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not parse as URI: %w", err))
+	}
+	docSnap, err := client.Collection("replies").Doc(Sluggify(*replyURI)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not get doc: %w", err))
+	}
+	var reply ap.Reply
+	err = docSnap.DataTo(&reply)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not get reply: %w", err))
+	}
+
+	// attachments, earlier versions' attachments, and custom emoji
+	media := reply.Attachment
+	for _, revision := range reply.History {
+		media = append(media, revision.Attachment...)
+	}
+	for _, tag := range reply.Tag {
+		if tag.Icon != nil {
+			media = append(media, *tag.Icon)
+		}
+	}
+	for _, attachment := range media {
+		if attachment.URL == mediaURL {
+			return &events.APIGatewayProxyResponse{
+				StatusCode: 200,
+				Body:       mediaURL,
+			}, nil
+		}
+	}
+	slog.Warn("media not on reply", "url", mediaURL, "reply", replyID)
+	return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+}
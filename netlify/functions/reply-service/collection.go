@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const pageSize = 20
+
+// Serves the replies to a post, or with ?reply= to one of its replies, as an
+// OrderedCollection whose pages embed each reply with a pointer to its own
+// replies collection. This lets other servers backfill a whole thread.
+func handleAPReplies(ctx context.Context, client *firestore.Client, request LambdaRequest, postURI string) (*LambdaResponse, error) {
+	collectionID := postURI + "/replies"
+	parentURI := postURI
+	if replyParam := request.QueryStringParameters["reply"]; replyParam != "" {
+		if _, err := url.Parse(replyParam); err != nil {
+			return GetLambdaResp(fmt.Errorf("%w: malformed reply id", ErrBadRequest))
+		}
+		err := checkInThread(ctx, client, replyParam, postURI)
+		if err != nil {
+			return GetLambdaResp(err)
+		}
+		parentURI = replyParam
+		collectionID = nestedCollectionID(postURI, replyParam)
+	}
+
+	parent, err := getReply(ctx, client, parentURI)
+	if status.Code(err) == codes.NotFound {
+		if parentURI != postURI {
+			return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+		}
+		// a post nobody has replied to yet
+		parent, err = &ap.Reply{Id: postURI}, nil
+	}
+	if err != nil {
+		return GetErrorResp(err)
+	}
+	ids := replyIDs(parent)
+
+	lastPage := max(1, (len(ids)+pageSize-1)/pageSize)
+	pageParam := request.QueryStringParameters["page"]
+	if pageParam == "" {
+		return activityJSONResp(map[string]any{
+			"@context":   "https://www.w3.org/ns/activitystreams",
+			"id":         collectionID,
+			"type":       "OrderedCollection",
+			"totalItems": len(ids),
+			"first":      pageID(collectionID, 1),
+			"last":       pageID(collectionID, lastPage),
+		})
+	}
+
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page < 1 || page > lastPage {
+		return GetLambdaResp(fmt.Errorf("%w: invalid page", ErrBadRequest))
+	}
+	start := (page - 1) * pageSize
+	end := min(start+pageSize, len(ids))
+
+	pageItems := []any{}
+	if start < end {
+		var refs []*firestore.DocumentRef
+		for _, id := range ids[start:end] {
+			refs = append(refs, docRef(client, "replies", id))
+		}
+		docs, err := client.GetAll(ctx, refs)
+		if err != nil {
+			return GetErrorResp(fmt.Errorf("could not get replies: %w", err))
+		}
+		for i, doc := range docs {
+			if !doc.Exists() {
+				// still list it, the origin server can tell what became of it
+				pageItems = append(pageItems, ids[start+i])
+				continue
+			}
+			reply, err := decodeReplyDoc(doc, false)
+			if err != nil {
+				return GetErrorResp(err)
+			}
+			pageItems = append(pageItems, replyObject(reply, postURI))
+		}
+	}
+
+	collectionPage := map[string]any{
+		"@context":     ap.ObjectContext,
+		"id":           pageID(collectionID, page),
+		"type":         "OrderedCollectionPage",
+		"partOf":       collectionID,
+		"totalItems":   len(ids),
+		"orderedItems": pageItems,
+	}
+	if page > 1 {
+		collectionPage["prev"] = pageID(collectionID, page-1)
+	}
+	if page < lastPage {
+		collectionPage["next"] = pageID(collectionID, page+1)
+	}
+
+	return activityJSONResp(collectionPage)
+}
+
+func nestedCollectionID(postURI, replyID string) string {
+	return postURI + "/replies?reply=" + url.QueryEscape(replyID)
+}
+
+func pageID(collectionID string, page int) string {
+	u, _ := url.Parse(collectionID)
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Our copy of a reply, as the object its author published, except that its
+// replies point back to us
+func replyObject(r *ap.Reply, postURI string) any {
+	if r.Type == "Tombstone" {
+		return map[string]any{"id": r.Id, "type": "Tombstone"}
+	}
+	repliesID := nestedCollectionID(postURI, r.Id)
+	return &ap.Object{
+		Id:           r.Id,
+		Type:         r.Type,
+		Summary:      r.Summary,
+		Sensitive:    r.Sensitive,
+		Content:      r.Content,
+		URL:          r.URL,
+		AttributedTo: r.AttributedTo,
+		InReplyTo:    ap.GetLinkOrObjectID(r.InReplyTo),
+		To:           r.To,
+		Cc:           r.Cc,
+		Published:    r.Published,
+		Updated:      r.Updated,
+		Attachment:   r.Attachment,
+		Tag:          r.Tag,
+		Replies: map[string]any{
+			"id":         repliesID,
+			"type":       "OrderedCollection",
+			"totalItems": len(r.Replies.Items),
+			"first":      pageID(repliesID, 1),
+		},
+	}
+}
+
+func activityJSONResp(body any) (*LambdaResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("couldn't marshal collection: %w", err))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/activity+json",
+		},
+		Body: string(data),
+	}, nil
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/firestore"
@@ -12,6 +16,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/maxbanister/blog/netlify/ap"
 	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
 	. "github.com/maxbanister/blog/netlify/util"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -21,19 +26,62 @@ func main() {
 	lambda.Start(handle)
 }
 
+// Bounds on how much of a thread one request loads. Branches cut off by
+// these get a cursor to load the rest with.
+const (
+	defaultDepth = 5
+	maxDepth     = 10
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+type treeOptions struct {
+	depth int
+	limit int
+	// "oldest", "newest" or "liked"
+	sort string
+	// include the earlier versions of edited replies
+	history bool
+}
+
+// Points to where a partially loaded list of replies left off
+type cursor struct {
+	Parent string `json:"p"`
+	Offset int    `json:"o"`
+}
+
 func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
 	host := GetHostSite()
 	// extract the referred to post from the query parameters
 	postID := request.QueryStringParameters["id"]
-	fmt.Println("Got request for", postID)
+	slog.Info("got request", "post", postID)
 
-	// if accept is of type application/ld+json or /activity+json, return only
-	// shallow replies with external reference IDs to reply objects
+	// if accept is of type application/ld+json or /activity+json, return the
+	// paged ActivityPub collection rather than the tree the site renders
 	wantsAP := false
 	a := strings.ToLower(request.Headers["accept"])
 	if strings.Contains(a, "activity+json") || strings.Contains(a, "ld+json") {
 		wantsAP = true
 	}
+	// the site's own pages ask for the tree, which needn't be signed for
+	if err := ap.VerifyCollectionFetch(&request, wantsAP, "id"); err != nil {
+		return GetLambdaResp(err)
+	}
+
+	opts, err := parseTreeOptions(request.QueryStringParameters)
+	if err != nil {
+		return GetLambdaResp(err)
+	}
+	postURIString := host + "/posts/" + postID
+	rootURI, offset := postURIString, 0
+	if cursorParam := request.QueryStringParameters["cursor"]; cursorParam != "" {
+		c, err := decodeCursor(cursorParam)
+		if err != nil {
+			return GetLambdaResp(err)
+		}
+		rootURI, offset = c.Parent, c.Offset
+	}
 
 	client, err := kv.GetFirestoreClient()
 	if err != nil {
@@ -41,8 +89,19 @@ func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error)
 	}
 	defer client.Close()
 
-	postURIString := host + "/posts/" + postID
-	r, err := GetReplyTree(client, postURIString, wantsAP)
+	// a cursor names any reply, which must be one under this post
+	if rootURI != postURIString {
+		err := checkInThread(ctx, client, rootURI, postURIString)
+		if err != nil {
+			return GetLambdaResp(err)
+		}
+	}
+
+	if wantsAP {
+		return handleAPReplies(ctx, client, request, postURIString)
+	}
+
+	r, err := GetReplyTree(ctx, client, rootURI, offset, opts)
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			// dummy object that has no replies
@@ -54,73 +113,309 @@ func handle(ctx context.Context, request LambdaRequest) (*LambdaResponse, error)
 		}
 	}
 
-	if !wantsAP {
-		body, err := json.Marshal(r.Replies)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't marshal reply tree json: %w", err)
-		}
-		return &events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers: map[string]string{
-				"Content-Type": "application/json; charset=utf-8",
-			},
-			Body: string(body),
-		}, nil
-	}
-
-	// Simple one-deep tree for ActivityPub compliance
-	replyItems, _ := json.MarshalIndent(r.Replies.Items, "	", "	")
-	body := fmt.Sprintf(`{
-	"@context": "https://www.w3.org/ns/activitystreams",
-	"id": "%s",
-	"type": "OrderedCollection",
-	"totalItems": %d,
-	"items": %s
-}`, r.Replies.Id, len(r.Replies.Items), string(replyItems))
-
+	body, err := json.Marshal(r.Replies)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't marshal reply tree json: %w", err)
+	}
 	return &events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Headers: map[string]string{
-			"Content-Type": "application/activity+json",
+			"Content-Type": "application/json; charset=utf-8",
 		},
-		Body: body,
+		Body: string(body),
 	}, nil
 }
 
-func GetReplyTree(client *firestore.Client, replyURI string, shallow bool) (*ap.Reply, error) {
+func parseTreeOptions(params map[string]string) (treeOptions, error) {
+	opts := treeOptions{depth: defaultDepth, limit: defaultLimit, sort: "oldest"}
+	var err error
+	if d := params["depth"]; d != "" {
+		opts.depth, err = strconv.Atoi(d)
+		if err != nil || opts.depth < 1 || opts.depth > maxDepth {
+			return opts, fmt.Errorf("%w: depth must be from 1 to %d",
+				ErrBadRequest, maxDepth)
+		}
+	}
+	if l := params["limit"]; l != "" {
+		opts.limit, err = strconv.Atoi(l)
+		if err != nil || opts.limit < 1 || opts.limit > maxLimit {
+			return opts, fmt.Errorf("%w: limit must be from 1 to %d",
+				ErrBadRequest, maxLimit)
+		}
+	}
+	if h := params["history"]; h != "" {
+		opts.history, err = strconv.ParseBool(h)
+		if err != nil {
+			return opts, fmt.Errorf("%w: history must be true or false",
+				ErrBadRequest)
+		}
+	}
+	if s := params["sort"]; s != "" {
+		if s != "oldest" && s != "newest" && s != "liked" {
+			return opts, fmt.Errorf("%w: unknown sort %q", ErrBadRequest, s)
+		}
+		opts.sort = s
+	}
+	return opts, nil
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Parent == "" || c.Offset < 0 {
+		return nil, fmt.Errorf("%w: bad cursor", ErrBadRequest)
+	}
+	return &c, nil
+}
+
+func getReply(ctx context.Context, client *firestore.Client, replyURI string) (*ap.Reply, error) {
 	replyID, err := url.Parse(replyURI)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse as URI: %w", err)
 	}
-	slugPostID := Sluggify(*replyID)
-
-	ctx := context.Background()
-	replyDoc, err := client.Collection("replies").Doc(slugPostID).Get(ctx)
+	replyDoc, err := client.Collection("replies").Doc(Sluggify(*replyID)).Get(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return decodeReplyDoc(replyDoc, false)
+}
+
+// Fails unless the reply is under the post. Replies know their thread's root,
+// and only those stored before roots were kept have their inReplyTo followed,
+// as far as maxDepth.
+func checkInThread(ctx context.Context, client *firestore.Client, replyURI, postURI string) error {
+	notInThread := fmt.Errorf("%w: %s is not a reply to this post", ErrBadRequest,
+		replyURI)
+	id := replyURI
+	for range maxDepth {
+		r, err := getReply(ctx, client, id)
+		if status.Code(err) == codes.NotFound {
+			return notInThread
+		}
+		if err != nil {
+			return err
+		}
+		if r.Root != "" {
+			if sameDoc(r.Root, postURI) {
+				return nil
+			}
+			return notInThread
+		}
+		parentID, _ := r.InReplyTo.(string)
+		if parentID == "" {
+			return notInThread
+		}
+		if sameDoc(parentID, postURI) {
+			return nil
+		}
+		id = parentID
+	}
+	return notInThread
+}
+
+// Whether two URIs name the same document, as they're stored by their slugs
+func sameDoc(a, b string) bool {
+	aURI, errA := url.Parse(a)
+	bURI, errB := url.Parse(b)
+	return errA == nil && errB == nil && Sluggify(*aURI) == Sluggify(*bURI)
+}
 
+// Edit history is left out unless asked for, as it's rarely looked at
+func decodeReplyDoc(doc *firestore.DocumentSnapshot, withHistory bool) (*ap.Reply, error) {
 	var r ap.Reply
-	err = replyDoc.DataTo(&r)
+	err := doc.DataTo(&r)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't populate struct with doc: %w", err)
 	}
-	if shallow {
-		return &r, nil
+	// sanitized again on the way out, in case of replies stored before
+	// sanitizing on write or under an older allowlist
+	r.Content = ap.SanitizeHTML(r.Content)
+	if !withHistory {
+		r.History = nil
+	}
+	for i := range r.History {
+		r.History[i].Content = ap.SanitizeHTML(r.History[i].Content)
+	}
+	return &r, nil
+}
+
+// GetReplyTree loads the replies under replyURI a level at a time, with one
+// batched read per level, until opts.depth levels or opts.limit replies have
+// been loaded. Lists of replies that were cut short are left with a Next
+// cursor. offset skips that many of the root's direct replies, for cursors.
+func GetReplyTree(ctx context.Context, client *firestore.Client, replyURI string, offset int, opts treeOptions) (*ap.Reply, error) {
+	root, err := getReply(ctx, client, replyURI)
+	if err != nil {
+		return nil, err
+	}
+
+	load := func(ids []string) ([]*ap.Reply, error) {
+		refs := make([]*firestore.DocumentRef, len(ids))
+		for i, id := range ids {
+			refs[i] = docRef(client, "replies", id)
+		}
+		docs, err := client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("could not get replies: %w", err)
+		}
+		replies := make([]*ap.Reply, len(docs))
+		for i, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			replies[i], err = decodeReplyDoc(doc, opts.history)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	var byLikes func([][]string) error
+	if opts.sort == "liked" {
+		byLikes = func(idLists [][]string) error {
+			return sortByLikes(ctx, client, idLists)
+		}
+	}
+
+	err = growTree(root, offset, opts, load, byLikes)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Fills in the tree under root a level at a time. load reads a level's
+// replies, leaving nil for any that are missing, and byLikes, when sorting by
+// likes, orders each list of IDs.
+func growTree(root *ap.Reply, offset int, opts treeOptions,
+	load func(ids []string) ([]*ap.Reply, error),
+	byLikes func(idLists [][]string) error) error {
+	remaining := opts.limit
+	level := []*ap.Reply{root}
+	for depth := 0; len(level) > 0; depth++ {
+		childIDs := make([][]string, len(level))
+		for i, r := range level {
+			childIDs[i] = replyIDs(r)
+			r.Replies.Items = nil
+		}
+		if byLikes != nil {
+			if err := byLikes(childIDs); err != nil {
+				return err
+			}
+		}
+
+		// pick out which children to load, cutting off the rest
+		var ids []string
+		var parents []*ap.Reply
+		for i, r := range level {
+			rIDs := childIDs[i]
+			if opts.sort == "newest" {
+				slices.Reverse(rIDs)
+			}
+			skip := 0
+			if r == root {
+				skip = min(offset, len(rIDs))
+			}
+			take := 0
+			if depth < opts.depth {
+				take = min(remaining, len(rIDs)-skip)
+			}
+			for _, id := range rIDs[skip : skip+take] {
+				ids = append(ids, id)
+				parents = append(parents, r)
+			}
+			remaining -= take
+			r.Replies.TotalItems = len(rIDs)
+			if skip+take < len(rIDs) {
+				r.Replies.Next = encodeCursor(cursor{r.Id, skip + take})
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		children, err := load(ids)
+		if err != nil {
+			return err
+		}
+		var nextLevel []*ap.Reply
+		for i, child := range children {
+			if child == nil {
+				slog.Warn("linked reply missing", "id", ids[i])
+				continue
+			}
+			parents[i].Replies.Items = append(parents[i].Replies.Items, child)
+			nextLevel = append(nextLevel, child)
+		}
+		level = nextLevel
 	}
+	return nil
+}
 
-	for i, item := range r.Replies.Items {
+// Items are kept in the order the replies arrived in, which is oldest first
+func replyIDs(r *ap.Reply) []string {
+	var ids []string
+	for _, item := range r.Replies.Items {
 		itemStr, ok := item.(string)
 		if !ok {
-			fmt.Println("warning: linked reply item is not string:", item)
+			slog.Warn("linked reply item is not a string", "item", item)
 			continue
 		}
-		subTree, err := GetReplyTree(client, itemStr, false)
-		if err != nil {
-			return nil, err
+		if _, err := url.Parse(itemStr); err != nil {
+			slog.Warn("linked reply item is not a URI", "item", itemStr)
+			continue
 		}
-		r.Replies.Items[i] = subTree
+		ids = append(ids, itemStr)
 	}
+	return ids
+}
 
-	return &r, nil
+// The doc for replyURI in colName; replyURI must already have been parsed
+func docRef(client *firestore.Client, colName, replyURI string) *firestore.DocumentRef {
+	u, _ := url.Parse(replyURI)
+	return client.Collection(colName).Doc(Sluggify(*u))
+}
+
+// Orders each list of reply IDs by how many likes we've recorded for them,
+// keeping arrival order among ties
+func sortByLikes(ctx context.Context, client *firestore.Client, idLists [][]string) error {
+	var refs []*firestore.DocumentRef
+	for _, ids := range idLists {
+		for _, id := range ids {
+			refs = append(refs, docRef(client, "likes", id))
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return fmt.Errorf("could not get like counts: %w", err)
+	}
+
+	likes := make(map[string]int, len(docs))
+	i := 0
+	for _, ids := range idLists {
+		for _, id := range ids {
+			var container struct{ Items []string }
+			if docs[i].Exists() && docs[i].DataTo(&container) == nil {
+				likes[id] = len(container.Items)
+			}
+			i++
+		}
+	}
+	for _, ids := range idLists {
+		slices.SortStableFunc(ids, func(a, b string) int {
+			return likes[b] - likes[a]
+		})
+	}
+	return nil
 }
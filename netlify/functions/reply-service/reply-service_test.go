@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func TestParseTreeOptions(t *testing.T) {
+	defaults := treeOptions{depth: defaultDepth, limit: defaultLimit, sort: "oldest"}
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    treeOptions
+		wantErr bool
+	}{
+		{"defaults", map[string]string{}, defaults, false},
+		{"all set", map[string]string{
+			"depth": "3", "limit": "7", "history": "true", "sort": "liked",
+		}, treeOptions{depth: 3, limit: 7, sort: "liked", history: true}, false},
+		{"max depth", map[string]string{"depth": fmt.Sprint(maxDepth)},
+			treeOptions{depth: maxDepth, limit: defaultLimit, sort: "oldest"}, false},
+		{"depth too deep", map[string]string{"depth": fmt.Sprint(maxDepth + 1)},
+			treeOptions{}, true},
+		{"zero depth", map[string]string{"depth": "0"}, treeOptions{}, true},
+		{"depth not a number", map[string]string{"depth": "deep"}, treeOptions{}, true},
+		{"limit too high", map[string]string{"limit": fmt.Sprint(maxLimit + 1)},
+			treeOptions{}, true},
+		{"negative limit", map[string]string{"limit": "-1"}, treeOptions{}, true},
+		{"bad history", map[string]string{"history": "maybe"}, treeOptions{}, true},
+		{"unknown sort", map[string]string{"sort": "random"}, treeOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTreeOptions(tt.params)
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadRequest) {
+					t.Errorf("got %v, want a bad request", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := cursor{"https://example.com/notes/1", 20}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil || *got != c {
+		t.Errorf("round trip gave %+v, %v", got, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"not base64!",
+		encodeCursor(cursor{"", 1}),
+		encodeCursor(cursor{"https://example.com/notes/1", -1}),
+	} {
+		if _, err := decodeCursor(bad); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("decodeCursor(%q) gave %v, want a bad request", bad, err)
+		}
+	}
+}
+
+func TestSameDoc(t *testing.T) {
+	if !sameDoc("https://blog.example/posts/hello/", "https://blog.example/posts/hello") {
+		t.Error("trailing slash made a different doc")
+	}
+	if sameDoc("https://blog.example/posts/hello", "https://blog.example/posts/other") {
+		t.Error("different posts made the same doc")
+	}
+}
+
+// A post with three replies, the first of which has two of its own
+func testThread() map[string]*ap.Reply {
+	thread := map[string]*ap.Reply{}
+	add := func(id string, children ...string) {
+		r := &ap.Reply{Id: id}
+		for _, child := range children {
+			r.Replies.Items = append(r.Replies.Items, child)
+		}
+		thread[id] = r
+	}
+	add("post", "a", "b", "c")
+	add("a", "a1", "a2")
+	add("b")
+	add("c")
+	add("a1")
+	add("a2")
+	return thread
+}
+
+// Grows the test thread from root, returning the tree's IDs as nested lists
+func grow(t *testing.T, root string, offset int, opts treeOptions) (*ap.Reply, []any) {
+	t.Helper()
+	thread := testThread()
+	load := func(ids []string) ([]*ap.Reply, error) {
+		replies := make([]*ap.Reply, len(ids))
+		for i, id := range ids {
+			replies[i] = thread[id]
+		}
+		return replies, nil
+	}
+	tree := thread[root]
+	if err := growTree(tree, offset, opts, load, nil); err != nil {
+		t.Fatal(err)
+	}
+	return tree, shape(tree)
+}
+
+func shape(r *ap.Reply) []any {
+	var items []any
+	for _, item := range r.Replies.Items {
+		child := item.(*ap.Reply)
+		if len(child.Replies.Items) > 0 {
+			items = append(items, []any{child.Id, shape(child)})
+		} else {
+			items = append(items, child.Id)
+		}
+	}
+	return items
+}
+
+func TestGrowTree(t *testing.T) {
+	all := treeOptions{depth: maxDepth, limit: maxLimit, sort: "oldest"}
+
+	tree, got := grow(t, "post", 0, all)
+	want := []any{[]any{"a", []any{"a1", "a2"}}, "b", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("whole tree %v, want %v", got, want)
+	}
+	if tree.Replies.TotalItems != 3 || tree.Replies.Next != "" {
+		t.Errorf("whole tree total %d, next %q", tree.Replies.TotalItems,
+			tree.Replies.Next)
+	}
+
+	newest := all
+	newest.sort = "newest"
+	if _, got := grow(t, "post", 0, newest); !reflect.DeepEqual(got,
+		[]any{"c", "b", []any{"a", []any{"a2", "a1"}}}) {
+		t.Errorf("newest first %v", got)
+	}
+
+	// the limit is spent a level at a time, so the post's replies come first
+	limited := all
+	limited.limit = 4
+	tree, got = grow(t, "post", 0, limited)
+	if want := []any{[]any{"a", []any{"a1"}}, "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited tree %v, want %v", got, want)
+	}
+	a := tree.Replies.Items[0].(*ap.Reply)
+	next, err := decodeCursor(a.Replies.Next)
+	if err != nil || *next != (cursor{"a", 1}) {
+		t.Errorf("cut short list's cursor %+v, %v", next, err)
+	}
+	if a.Replies.TotalItems != 2 {
+		t.Errorf("cut short list's total %d, want 2", a.Replies.TotalItems)
+	}
+
+	// following that cursor gives the rest
+	tree, got = grow(t, "a", next.Offset, all)
+	if want := []any{"a2"}; !reflect.DeepEqual(got, want) || tree.Replies.Next != "" {
+		t.Errorf("after cursor %v, next %q", got, tree.Replies.Next)
+	}
+
+	shallow := all
+	shallow.depth = 1
+	tree, got = grow(t, "post", 0, shallow)
+	a = tree.Replies.Items[0].(*ap.Reply)
+	if !reflect.DeepEqual(got, []any{"a", "b", "c"}) || a.Replies.Next == "" {
+		t.Errorf("depth 1 tree %v, a's next %q", got, a.Replies.Next)
+	}
+
+	// an offset past the end leaves nothing
+	tree, got = grow(t, "post", 10, all)
+	if got != nil || tree.Replies.Next != "" {
+		t.Errorf("offset past the end %v, next %q", got, tree.Replies.Next)
+	}
+}
+
+func TestGrowTreeMissingReply(t *testing.T) {
+	root := &ap.Reply{Id: "post", Replies: ap.InnerReplies{Items: []any{"gone", "here"}}}
+	load := func(ids []string) ([]*ap.Reply, error) {
+		return []*ap.Reply{nil, {Id: "here"}}, nil
+	}
+	opts := treeOptions{depth: 1, limit: 10}
+	if err := growTree(root, 0, opts, load, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := shape(root); !reflect.DeepEqual(got, []any{"here"}) {
+		t.Errorf("got %v, want only the stored reply", got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/logging"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+type JRD struct {
+	Subject string    `json:"subject,omitempty"`
+	Aliases []string  `json:"aliases,omitempty"`
+	Links   []JRDLink `json:"links"`
+}
+
+type JRDLink struct {
+	Rel      string `json:"rel" xml:"rel,attr"`
+	Type     string `json:"type,omitempty" xml:"type,attr,omitempty"`
+	Href     string `json:"href,omitempty" xml:"href,attr,omitempty"`
+	Template string `json:"template,omitempty" xml:"template,attr,omitempty"`
+}
+
+func main() {
+	lambda.Start(handleWebfinger)
+}
+
+// Answers WebFinger (RFC 7033) queries for our accounts, along with the
+// host-meta documents (RFC 6415) older software looks WebFinger up through
+func handleWebfinger(request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	host := GetHostSite()
+	switch request.QueryStringParameters["doc"] {
+	case "host-meta":
+		return hostMeta(host)
+	case "host-meta.json":
+		return jsonResp(&JRD{Links: []JRDLink{lrddLink(host)}}, "application/json")
+	case "authorize_interaction":
+		return authorizeInteraction(request.QueryStringParameters["uri"], host)
+	}
+
+	resource := request.QueryStringParameters["resource"]
+	if resource == "" {
+		return GetLambdaResp(fmt.Errorf("%w: resource not given", ErrBadRequest))
+	}
+	actor, ok := findAccount(resource, host)
+	if !ok {
+		return &events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound}, nil
+	}
+	return jsonResp(accountJRD(actor, host), "application/jrd+json")
+}
+
+// Finds which of our accounts a resource names, whether as acct:user@host,
+// the actor's ID, or one of its aliases
+func findAccount(resource, host string) (*ap.LocalActor, bool) {
+	_, hostName, _ := strings.Cut(host, "//")
+	resource = strings.TrimSpace(resource)
+
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok || !strings.Contains(resource, "://") {
+		user, domain, found := strings.Cut(strings.TrimPrefix(acct, "@"), "@")
+		if !found || !strings.EqualFold(domain, hostName) {
+			return nil, false
+		}
+		for _, actor := range accounts() {
+			if strings.EqualFold(actor.Username, user) {
+				return actor, true
+			}
+		}
+		return nil, false
+	}
+
+	resourceURI, err := url.Parse(resource)
+	if err != nil || !strings.EqualFold(resourceURI.Host, hostName) {
+		return nil, false
+	}
+	for _, actor := range accounts() {
+		for _, alias := range aliases(actor, host) {
+			if strings.TrimSuffix(resource, "/") == strings.TrimSuffix(alias, "/") {
+				return actor, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Our actors, and the instance actor once it has a key to be fetched with.
+// Mastodon finds the instance actor as acct:domain@domain.
+func accounts() []*ap.LocalActor {
+	if len(ap.InstanceActor.Keys) == 0 {
+		return ap.LocalActors
+	}
+	return append(slices.Clip(ap.LocalActors), ap.InstanceActor)
+}
+
+// The actor, and the page its profile links to. Only the default actor
+// answers for the front page.
+func aliases(actor *ap.LocalActor, host string) []string {
+	aliases := []string{actor.ID()}
+	if actor == ap.DefaultActor() || actor.ProfilePage != "/" {
+		aliases = append(aliases, host+actor.ProfilePage)
+	}
+	return aliases
+}
+
+func accountJRD(actor *ap.LocalActor, host string) *JRD {
+	_, hostName, _ := strings.Cut(host, "//")
+	return &JRD{
+		Subject: "acct:" + actor.Username + "@" + hostName,
+		Aliases: aliases(actor, host),
+		Links: []JRDLink{
+			{
+				Rel:  "self",
+				Type: "application/activity+json",
+				Href: actor.ID(),
+			},
+			{
+				Rel:  "http://webfinger.net/rel/profile-page",
+				Type: "text/html",
+				Href: host + actor.ProfilePage,
+			},
+			{
+				Rel:      "http://ostatus.org/schema/1.0/subscribe",
+				Template: host + "/authorize_interaction?uri={uri}",
+			},
+		},
+	}
+}
+
+func lrddLink(host string) JRDLink {
+	return JRDLink{
+		Rel:      "lrdd",
+		Type:     "application/jrd+json",
+		Template: host + "/.well-known/webfinger?resource={uri}",
+	}
+}
+
+func hostMeta(host string) (*LambdaResponse, error) {
+	type XRD struct {
+		XMLName xml.Name  `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD"`
+		Links   []JRDLink `xml:"Link"`
+	}
+	body, err := xml.MarshalIndent(XRD{Links: []JRDLink{lrddLink(host)}}, "", "  ")
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not marshal host-meta: %w", err))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":                "application/xrd+xml; charset=utf-8",
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: xml.Header + string(body),
+	}, nil
+}
+
+// There's nobody to sign in here to follow or interact from, so the
+// subscribe template just sends people to the actor or post they asked about.
+// Only our own are redirected to, so it can't send people anywhere else.
+func authorizeInteraction(uri, host string) (*LambdaResponse, error) {
+	var location string
+	if actor, ok := findAccount(uri, host); ok {
+		location = host + actor.ProfilePage
+	} else if target, err := url.Parse(strings.TrimSpace(uri)); err == nil &&
+		strings.EqualFold(target.Scheme+"://"+target.Host, host) &&
+		strings.HasPrefix(target.Path, "/posts/") {
+		location = host + target.EscapedPath()
+	}
+	if location == "" {
+		return GetLambdaResp(fmt.Errorf("%w: uri must be one of our actors or posts",
+			ErrBadRequest))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: http.StatusFound,
+		Headers:    map[string]string{"Location": location},
+	}, nil
+}
+
+func jsonResp(body any, contentType string) (*LambdaResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return GetErrorResp(fmt.Errorf("could not marshal JRD: %w", err))
+	}
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":                contentType,
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(data),
+	}, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
+	"github.com/maxbanister/blog/netlify/kv"
+	"github.com/maxbanister/blog/netlify/logging"
+	"github.com/maxbanister/blog/netlify/mf2"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The most of a source page we'll read looking for the link to our post
+const maxSourceSize = 1 << 20
+
+var postPath = regexp.MustCompile(`^/posts/([^/]+)/?$`)
+
+var fetchClient = &http.Client{Timeout: 10 * time.Second}
+
+func main() {
+	lambda.Start(handleWebmention)
+}
+
+// Receives a webmention (https://www.w3.org/TR/webmention/) and files it
+// alongside the fediverse's interactions: replies into the comment tree,
+// likes and reposts into likes and shares
+func handleWebmention(ctx context.Context, request LambdaRequest) (*LambdaResponse, error) {
+	logging.Start(request.Headers)
+	HOST_SITE := GetHostSite()
+	slog.Debug("got webmention", "body", request.Body)
+
+	if !config.Site.Features.Webmentions {
+		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+	}
+	if request.HTTPMethod != http.MethodPost {
+		return &events.APIGatewayProxyResponse{StatusCode: 405}, nil
+	}
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return GetLambdaResp(fmt.Errorf("%w: bad body encoding", ErrBadRequest))
+		}
+		body = string(decoded)
+	}
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return GetLambdaResp(fmt.Errorf("%w: malformed form: %w", ErrBadRequest, err))
+	}
+
+	source, target, err := checkMention(form.Get("source"), form.Get("target"), HOST_SITE)
+	if err != nil {
+		return GetLambdaResp(err)
+	}
+	postURI := HOST_SITE + "/posts/" + postPath.FindStringSubmatch(target.Path)[1] + "/"
+
+	err = HandleMention(ctx, source, target, postURI)
+	if err != nil {
+		return GetLambdaResp(err)
+	}
+	return &events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// Validates source and target, and that target is one of our posts
+func checkMention(sourceStr, targetStr, host string) (*url.URL, *url.URL, error) {
+	source, err := url.Parse(sourceStr)
+	if err != nil || (source.Scheme != "https" && source.Scheme != "http") {
+		return nil, nil, fmt.Errorf("%w: source must be an http(s) URL", ErrBadRequest)
+	}
+	target, err := url.Parse(targetStr)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") {
+		return nil, nil, fmt.Errorf("%w: target must be an http(s) URL", ErrBadRequest)
+	}
+	if sameURL(source.String(), target.String()) {
+		return nil, nil, fmt.Errorf("%w: source and target are the same", ErrBadRequest)
+	}
+	_, hostName, _ := strings.Cut(host, "//")
+	if target.Host != hostName || !postPath.MatchString(target.Path) {
+		return nil, nil, fmt.Errorf("%w: target is not a post on this site", ErrBadRequest)
+	}
+	resp, err := http.Head(host + target.Path)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%w: target post nonexistent", ErrBadRequest)
+	}
+	return source, target, nil
+}
+
+// Fetches the source and stores what it says about the post, or removes
+// what it used to say if it's gone or no longer links to the post
+func HandleMention(ctx context.Context, source, target *url.URL, postURI string) error {
+	slog.Info("fetching source", "source", source)
+	resp, err := fetchClient.Get(source.String())
+	if err != nil {
+		return fmt.Errorf("%w: could not fetch source: %w", ErrBadRequest, err)
+	}
+	defer resp.Body.Close()
+
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return removeMention(ctx, client, source)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: source returned %s", ErrBadRequest, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return fmt.Errorf("%w: source is not HTML", ErrBadRequest)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return fmt.Errorf("%w: could not read source: %w", ErrBadRequest, err)
+	}
+	// redirects may have moved us, and relative links are relative to that
+	base := resp.Request.URL
+
+	links, err := mf2.Links(bytes.NewReader(page), base)
+	if err != nil {
+		return fmt.Errorf("%w: could not parse source: %w", ErrBadRequest, err)
+	}
+	if !slices.ContainsFunc(links, func(l string) bool { return sameURL(l, target.String()) }) {
+		slog.Info("source no longer links to target", "target", target)
+		return removeMention(ctx, client, source)
+	}
+
+	items, err := mf2.Parse(bytes.NewReader(page), base)
+	if err != nil {
+		return fmt.Errorf("%w: could not parse source: %w", ErrBadRequest, err)
+	}
+	entry := findEntry(items, source)
+	if entry == nil {
+		slog.Info("no h-entry in source, treating as a plain mention")
+		return nil
+	}
+	author := entryAuthor(entry, source)
+
+	mentions := func(prop string) bool {
+		return slices.ContainsFunc(entry.Strings(prop), func(u string) bool {
+			return sameURL(u, target.String())
+		})
+	}
+	switch {
+	case mentions("in-reply-to"):
+		return saveReply(ctx, client, entry, author, source, postURI)
+	case mentions("like-of"):
+		return saveEndorsement(ctx, client, "likes", author, source, postURI)
+	case mentions("repost-of"):
+		return saveEndorsement(ctx, client, "shares", author, source, postURI)
+	}
+	slog.Info("plain mention, nothing to store")
+	return nil
+}
+
+// Prefers the h-entry whose url is the source, as pages like feeds can have
+// several
+func findEntry(items []*mf2.Item, source *url.URL) *mf2.Item {
+	var entries []*mf2.Item
+	var collect func(items []*mf2.Item)
+	collect = func(items []*mf2.Item) {
+		for _, item := range items {
+			if item.HasType("h-entry") {
+				entries = append(entries, item)
+			}
+			collect(item.Children)
+		}
+	}
+	collect(items)
+
+	for _, entry := range entries {
+		if slices.ContainsFunc(entry.Strings("url"), func(u string) bool {
+			return sameURL(u, source.String())
+		}) {
+			return entry
+		}
+	}
+	if len(entries) > 0 {
+		return entries[0]
+	}
+	return nil
+}
+
+// Builds an actor out of the entry's h-card author, falling back to the
+// source's site when there isn't one. The author's url only becomes its ID
+// when it's on the source's host, so a page can't post as someone elsewhere,
+// such as a fediverse account or one of our own actors.
+func entryAuthor(entry *mf2.Item, source *url.URL) *ap.Actor {
+	siteURL := source.Scheme + "://" + source.Host + "/"
+	a := &ap.Actor{Id: siteURL, Name: source.Host, PreferredUsername: source.Host}
+
+	authors := entry.Properties["author"]
+	if len(authors) == 0 {
+		return a
+	}
+	switch v := authors[0].(type) {
+	case *mf2.Item:
+		if id := v.Get("url"); onSourceHost(id, source) {
+			a.Id = id
+		}
+		if name := v.Get("name"); name != "" {
+			a.Name = name
+			a.PreferredUsername = name
+		}
+		if photo := v.Get("photo"); isWebURL(photo) {
+			a.Icon = photo
+		}
+	case string:
+		if isWebURL(v) {
+			if onSourceHost(v, source) {
+				a.Id = v
+			}
+		} else if v != "" {
+			a.Name = v
+			a.PreferredUsername = v
+		}
+	}
+	return a
+}
+
+func saveReply(ctx context.Context, client *firestore.Client, entry *mf2.Item, author *ap.Actor, source *url.URL, postURI string) error {
+	replyObj := webmentionReply(entry, author, source, postURI)
+	err := kv.SaveReply(ctx, client, replyObj)
+	if status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+
+	// a resent webmention means the source was edited, though only replies
+	// that came as webmentions can be edited this way
+	slog.Info("updating reply", "id", replyObj.Id)
+	if replyObj.Updated == "" {
+		replyObj.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return kv.UpdateReply(ctx, client, replyObj)
+}
+
+// The reply a webmention's entry makes, keyed by its source
+func webmentionReply(entry *mf2.Item, author *ap.Actor, source *url.URL, postURI string) *ap.Reply {
+	replyObj := &ap.Reply{
+		Id:           source.String(),
+		Type:         "Note",
+		InReplyTo:    postURI,
+		URL:          source.String(),
+		AttributedTo: author.Id,
+		Published:    parseTime(entry.Get("published")),
+		Actor:        author,
+		Via:          ap.ViaWebmention,
+	}
+	// the ID stays the verified source, which is what a later webmention
+	// removing the reply names; a permalink on the same site is only linked to
+	if u, err := url.Parse(entry.Get("url")); err == nil && u.Host == source.Host {
+		replyObj.URL = u.String()
+	}
+	if updated := entry.Get("updated"); updated != "" {
+		replyObj.Updated = parseTime(updated)
+	}
+
+	if content, ok := firstHTML(entry, "content"); ok {
+		replyObj.RawContent = content.HTML
+		replyObj.Content = ap.SanitizeHTML(content.HTML)
+	}
+	if replyObj.Content == "" {
+		text := entry.Get("summary")
+		if text == "" {
+			text = entry.Get("name")
+		}
+		replyObj.RawContent = text
+		replyObj.Content = "<p>" + html.EscapeString(text) + "</p>"
+	}
+	return replyObj
+}
+
+func saveEndorsement(ctx context.Context, client *firestore.Client, colName string, author *ap.Actor, source *url.URL, postURI string) error {
+	return kv.SaveEndorsement(ctx, client, colName,
+		webmentionEndorsement(author, source, postURI))
+}
+
+func webmentionEndorsement(author *ap.Actor, source *url.URL, postURI string) *ap.LikeOrShare {
+	return &ap.LikeOrShare{
+		Id:     source.String(),
+		URL:    source.String(),
+		Object: postURI,
+		Actor:  author,
+		Via:    ap.ViaWebmention,
+	}
+}
+
+// Takes back whatever an earlier webmention from source stored. Anything
+// else stored under the same ID is left alone, as anyone can name any source.
+func removeMention(ctx context.Context, client *firestore.Client, source *url.URL) error {
+	err := kv.DeleteReply(ctx, client, source.String(), ap.ViaWebmention)
+	if status.Code(err) != codes.NotFound {
+		return err
+	}
+	for _, colName := range []string{"likes", "shares"} {
+		err := kv.DeleteEndorsement(ctx, client, colName, source.String(),
+			ap.ViaWebmention)
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+	}
+	slog.Info("nothing stored", "source", source)
+	return nil
+}
+
+func firstHTML(item *mf2.Item, prop string) (*mf2.HTML, bool) {
+	for _, v := range item.Properties[prop] {
+		if h, ok := v.(*mf2.HTML); ok {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// Microformats dates come in many shapes; anything unreadable becomes now
+func parseTime(s string) string {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func onSourceHost(s string, source *url.URL) bool {
+	u, err := url.Parse(s)
+	return isWebURL(s) && err == nil && strings.EqualFold(u.Host, source.Host)
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// Compares URLs ignoring a trailing slash
+func sameURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
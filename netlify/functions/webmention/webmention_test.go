@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/mf2"
+)
+
+const postURI = "https://blog.example/posts/hello/"
+
+func parseEntry(t *testing.T, source *url.URL, page string) *mf2.Item {
+	t.Helper()
+	items, err := mf2.Parse(strings.NewReader(page), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := findEntry(items, source)
+	if entry == nil {
+		t.Fatal("no h-entry")
+	}
+	return entry
+}
+
+func TestWebmentionReply(t *testing.T) {
+	source, _ := url.Parse("https://alice.example/2024/reply")
+	entry := parseEntry(t, source, `<article class="h-entry">
+		<a class="u-url" href="/2024/reply-permalink"></a>
+		<a class="u-in-reply-to" href="`+postURI+`"></a>
+		<span class="p-author h-card">
+			<a class="p-name u-url" href="https://mastodon.example/@alice">Alice</a>
+		</span>
+		<time class="dt-published" datetime="2024-01-02T03:04:05Z"></time>
+		<div class="e-content"><p>nice<script>alert(1)</script></p></div>
+	</article>`)
+	author := entryAuthor(entry, source)
+	reply := webmentionReply(entry, author, source, postURI)
+
+	if reply.Via != ap.ViaWebmention {
+		t.Errorf("via = %q, want it marked as a webmention", reply.Via)
+	}
+	// keyed by the source, which a later webmention removing it names
+	if reply.Id != source.String() {
+		t.Errorf("id = %q, want the source", reply.Id)
+	}
+	if reply.URL != "https://alice.example/2024/reply-permalink" {
+		t.Errorf("url = %q, want the same-site permalink", reply.URL)
+	}
+	// the author's fediverse account is on another host, so isn't taken
+	if author.Id != "https://alice.example/" || reply.AttributedTo != author.Id {
+		t.Errorf("author %q, attributed to %q", author.Id, reply.AttributedTo)
+	}
+	if reply.Content != "<p>nice</p>" {
+		t.Errorf("content = %q, want it sanitized", reply.Content)
+	}
+	if reply.InReplyTo != postURI || reply.Published != "2024-01-02T03:04:05Z" {
+		t.Errorf("in reply to %v, published %q", reply.InReplyTo, reply.Published)
+	}
+}
+
+func TestWebmentionReplyOffSiteURL(t *testing.T) {
+	source, _ := url.Parse("https://alice.example/2024/reply")
+	entry := parseEntry(t, source, `<article class="h-entry">
+		<a class="u-url" href="https://mastodon.example/@bob/1"></a>
+		<p class="p-name">just text</p>
+	</article>`)
+	reply := webmentionReply(entry, entryAuthor(entry, source), source, postURI)
+	if reply.URL != source.String() {
+		t.Errorf("url = %q, want the source rather than another site", reply.URL)
+	}
+	if reply.Content != "<p>just text</p>" {
+		t.Errorf("content = %q", reply.Content)
+	}
+}
+
+func TestWebmentionEndorsement(t *testing.T) {
+	source, _ := url.Parse("https://alice.example/likes/1")
+	author := &ap.Actor{Id: "https://alice.example/"}
+	like := webmentionEndorsement(author, source, postURI)
+	if like.Via != ap.ViaWebmention || like.Id != source.String() ||
+		like.Object != postURI {
+		t.Errorf("got %+v", like)
+	}
+}
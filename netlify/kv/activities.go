@@ -0,0 +1,58 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// Copy of an activity we sent, kept so its ID can be dereferenced later
+type StoredActivity struct {
+	Id        string
+	Type      string
+	Published string
+	Public    bool
+	Payload   string
+}
+
+func ActivitySlug(activityID string) (string, error) {
+	activityURI, err := url.Parse(activityID)
+	if err != nil {
+		return "", fmt.Errorf("could not parse activity ID: %w", err)
+	}
+	return Sluggify(*activityURI), nil
+}
+
+// Stores the exact payload that was sent for activity
+func SaveActivity(activity *ap.Activity, payload string) error {
+	slug, err := ActivitySlug(activity.Id)
+	if err != nil {
+		return err
+	}
+
+	client, err := GetFirestoreClient()
+	if err != nil {
+		return fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+
+	isPublic := slices.Contains(activity.To, ap.PublicAddress) ||
+		slices.Contains(activity.Cc, ap.PublicAddress)
+	_, err = client.Collection("activities").Doc(slug).Set(context.Background(),
+		StoredActivity{
+			Id:        activity.Id,
+			Type:      activity.Type,
+			Published: activity.Published,
+			Public:    isPublic,
+			Payload:   payload,
+		})
+	if err != nil {
+		return fmt.Errorf("could not store activity: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"cloud.google.com/go/firestore"
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SaveEndorsement stores a like or share in colName ("likes" or "shares")
+// and adds it to the list kept for the post it endorses. One already stored
+// is only replaced if it arrived the same way.
+func SaveEndorsement(ctx context.Context, client *firestore.Client, colName string, endorsement *ap.LikeOrShare) error {
+	objectURI, err := url.Parse(endorsement.Object)
+	if err != nil {
+		return fmt.Errorf("%w: malformed object URI: %w", ErrBadRequest, err)
+	}
+	endorseURI, err := url.Parse(endorsement.Id)
+	if err != nil {
+		return fmt.Errorf("%w: malformed ID URI: %w", ErrBadRequest, err)
+	}
+
+	collectionRef := client.Collection(colName)
+	objectDocRef := collectionRef.Doc(Sluggify(*objectURI))
+	endorseDocRef := collectionRef.Doc(Sluggify(*endorseURI))
+
+	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := tx.Get(endorseDocRef)
+		if err == nil {
+			err = checkVia(existing.Data(), endorsement.Via)
+		} else if status.Code(err) == codes.NotFound {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// add to object's list of likes/shares
+		err = tx.Set(objectDocRef, map[string]any{
+			"Id":    objectURI.JoinPath(colName).String(),
+			"Items": firestore.ArrayUnion(endorsement.Id),
+		}, firestore.MergeAll)
+		if err != nil {
+			return fmt.Errorf("failed to add item: %v", err)
+		}
+
+		// create like/share activity
+		data := map[string]any{
+			"Id":     endorsement.Id,
+			"URL":    endorsement.URL,
+			"Object": endorsement.Object,
+			"Actor":  endorsement.Actor,
+		}
+		if endorsement.Via != "" {
+			data["Via"] = endorsement.Via
+		}
+		err = tx.Set(endorseDocRef, data)
+		if err != nil {
+			return fmt.Errorf("failed to add item: %v", err)
+		}
+		return nil
+	}
+
+	return client.RunTransaction(ctx, txFunc)
+}
+
+// DeleteEndorsement removes a like or share that arrived by via, and takes it
+// off its post's list
+func DeleteEndorsement(ctx context.Context, client *firestore.Client, colName, endorsementID, via string) error {
+	objectIDURI, err := url.Parse(endorsementID)
+	if err != nil {
+		return fmt.Errorf("%w: malformed ID URI: %w", ErrBadRequest, err)
+	}
+	slugObjID := Sluggify(*objectIDURI)
+
+	collectionRef := client.Collection(colName)
+	likeOrShareDocRef := collectionRef.Doc(slugObjID)
+
+	// Need to get the ID of the post this like/share refers to from firestore
+	slog.Debug("getting", "collection", colName, "doc", slugObjID)
+	likeOrShareDoc, err := likeOrShareDocRef.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error looking up document: %w", err)
+	}
+	if err := checkVia(likeOrShareDoc.Data(), via); err != nil {
+		return err
+	}
+	originalPostURI, err := likeOrShareDoc.DataAt("Object")
+	originalPostURIStr, _ := originalPostURI.(string)
+	if originalPostURIStr == "" || err != nil {
+		return fmt.Errorf("error getting document data: %w", err)
+	}
+
+	opURI, err := url.Parse(originalPostURIStr)
+	if err != nil {
+		return fmt.Errorf("%w: malformed object URI: %w", ErrBadRequest, err)
+	}
+	slugOPURI := Sluggify(*opURI)
+	originalPostDocRef := collectionRef.Doc(slugOPURI)
+
+	slog.Info("attempting to remove", "collection", colName, "doc", slugObjID)
+
+	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
+		err = tx.Delete(likeOrShareDocRef)
+		if err != nil {
+			return fmt.Errorf("failed to get item: %w", err)
+		}
+		err = tx.Update(originalPostDocRef, []firestore.Update{
+			{Path: "Items", Value: firestore.ArrayRemove(endorsementID)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove item: %w", err)
+		}
+		return nil
+	}
+
+	return client.RunTransaction(ctx, txFunc)
+}
@@ -4,32 +4,36 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
+	"github.com/maxbanister/blog/netlify/config"
 	"google.golang.org/api/option"
 )
 
 const certURLPrefix = "https://www.googleapis.com/robot/v1/metadata/x509/firebase-adminsdk-fbsvc%40"
 
 func GetFirestoreClient() (*firestore.Client, error) {
+	storage := config.Site.Storage
+	if missing := storage.MissingCredentials(); len(missing) > 0 {
+		return nil, fmt.Errorf("missing firestore credentials: %s",
+			strings.Join(missing, ", "))
+	}
+
 	// Use a service account
 	serviceAccountJSON := map[string]string{
 		"type":                        "service_account",
-		"project_id":                  "max-banister-blog",
+		"project_id":                  storage.ProjectID,
 		"auth_uri":                    "https://accounts.google.com/o/oauth2/auth",
 		"token_uri":                   "https://oauth2.googleapis.com/token",
 		"auth_provider_x509_cert_url": "https://www.googleapis.com/oauth2/v1/certs",
 	}
-	client_email := os.Getenv("GOOGLE_CLIENT_EMAIL")
-	priv_key := strings.ReplaceAll(os.Getenv("GOOGLE_PRIV_KEY"), "\\n", "\n")
-	_, emailDomain, _ := strings.Cut(client_email, "@")
-	serviceAccountJSON["private_key_id"] = os.Getenv("GOOGLE_PRIV_KEY_ID")
-	serviceAccountJSON["private_key"] = priv_key
-	serviceAccountJSON["client_email"] = client_email
-	serviceAccountJSON["client_id"] = os.Getenv("GOOGLE_CLIENT_ID")
+	_, emailDomain, _ := strings.Cut(storage.ClientEmail, "@")
+	serviceAccountJSON["private_key_id"] = storage.PrivateKeyID
+	serviceAccountJSON["private_key"] = storage.PrivateKey
+	serviceAccountJSON["client_email"] = storage.ClientEmail
+	serviceAccountJSON["client_id"] = storage.ClientID
 	serviceAccountJSON["client_x509_cert_url"] = certURLPrefix + emailDomain
 	marshalledSA, err := json.Marshal(serviceAccountJSON)
 	if err != nil {
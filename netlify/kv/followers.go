@@ -0,0 +1,33 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/maxbanister/blog/netlify/ap"
+	"google.golang.org/api/iterator"
+)
+
+// Reads every follower of one of our actors
+func GetFollowers(ctx context.Context, client *firestore.Client, actor *ap.LocalActor) ([]*ap.Actor, error) {
+	var followers []*ap.Actor
+	iter := client.Collection(actor.FollowersCollection()).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not call iter next on collection: %w", err)
+		}
+		var follower ap.Actor
+		err = doc.DataTo(&follower)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert doc to Actor: %w", err)
+		}
+		followers = append(followers, &follower)
+	}
+	return followers, nil
+}
@@ -0,0 +1,202 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"cloud.google.com/go/firestore"
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SaveReply stores a reply and links it from the object it replies to. We
+// need to write two documents: the reply being added, and the original post
+// (which may not exist yet) to link it to the newly created reply. It fails
+// with codes.AlreadyExists if the reply is already stored.
+func SaveReply(ctx context.Context, client *firestore.Client, replyObj *ap.Reply) error {
+	inReplyTo, _ := replyObj.InReplyTo.(string)
+	inReplyToURI, err := url.Parse(inReplyTo)
+	if err != nil {
+		return fmt.Errorf("%w: malformed inReplyTo URI: %w", ErrBadRequest, err)
+	}
+	replyObjId, err := url.Parse(replyObj.Id)
+	if err != nil {
+		return fmt.Errorf("%w: malformed object id: %w", ErrBadRequest, err)
+	}
+	repliesCollection := client.Collection("replies")
+
+	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
+		root, err := threadRoot(tx, repliesCollection.Doc(Sluggify(*inReplyToURI)),
+			inReplyTo)
+		if err != nil {
+			return err
+		}
+		replyObj.Root = root
+
+		// this will fail if the reply ID already exists
+		newReplyDoc := repliesCollection.Doc(Sluggify(*replyObjId))
+		if err := tx.Create(newReplyDoc, replyObj); err != nil {
+			return err
+		}
+
+		// If it's the first comment to a top-level post, we will create a new
+		// reply document for it. Otherwise, we will just merge the reply sets.
+		// For replies-to-replies, the parent reply will already exist.
+		return tx.Set(repliesCollection.Doc(Sluggify(*inReplyToURI)), map[string]any{
+			"Id": inReplyTo,
+			"Replies": map[string]any{ // will clobber other fields in struct
+				"Id":    inReplyToURI.JoinPath("replies").String(),
+				"Items": firestore.ArrayUnion(replyObj.Id),
+			},
+		}, firestore.MergeAll)
+	}
+	return client.RunTransaction(ctx, txFunc)
+}
+
+// Finds the post a new reply's thread hangs from. A parent that isn't itself
+// a reply is the post; otherwise the reply shares its parent's root, which is
+// unknown for parents stored before roots were kept.
+func threadRoot(tx *firestore.Transaction, parentDoc *firestore.DocumentRef, parentID string) (string, error) {
+	doc, err := tx.Get(parentDoc)
+	if status.Code(err) == codes.NotFound {
+		return parentID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error accessing replies doc: %w", err)
+	}
+	var parent ap.Reply
+	err = doc.DataTo(&parent)
+	if err != nil {
+		return "", fmt.Errorf("could not convert document to struct: %w", err)
+	}
+	return parentRoot(&parent, parentID), nil
+}
+
+func parentRoot(parent *ap.Reply, parentID string) string {
+	if parent.InReplyTo == nil || parent.InReplyTo == "" {
+		return parentID
+	}
+	return parent.Root
+}
+
+// UpdateReply replaces the content of a stored reply that arrived the same
+// way replyObj did, keeping its place in the thread
+func UpdateReply(ctx context.Context, client *firestore.Client, replyObj *ap.Reply) error {
+	replyURI, err := url.Parse(replyObj.Id)
+	if err != nil {
+		return fmt.Errorf("%w: malformed object id: %w", ErrBadRequest, err)
+	}
+	docRef := client.Collection("replies").Doc(Sluggify(*replyURI))
+
+	txFunc := func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		if err := checkVia(doc.Data(), replyObj.Via); err != nil {
+			return err
+		}
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "Content", Value: replyObj.Content},
+			{Path: "RawContent", Value: replyObj.RawContent},
+			{Path: "Updated", Value: replyObj.Updated},
+			{Path: "URL", Value: replyObj.URL},
+			{Path: "Actor", Value: replyObj.Actor},
+		})
+	}
+	return client.RunTransaction(ctx, txFunc)
+}
+
+// DeleteReply removes a reply that arrived by via. One in the middle of a
+// thread is left as a Tombstone so its replies stay attached. It fails with
+// codes.NotFound if there's no such reply.
+func DeleteReply(ctx context.Context, client *firestore.Client, deleteID, via string) error {
+	replyURI, err := url.Parse(deleteID)
+	if err != nil {
+		return fmt.Errorf("%w: couldn't parse ID as URI: %w", ErrBadRequest, err)
+	}
+	slugDeleteID := Sluggify(*replyURI)
+
+	slog.Info("attempting to delete", "reply", slugDeleteID)
+	repliesCol := client.Collection("replies")
+	doc, err := repliesCol.Doc(slugDeleteID).Get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkVia(doc.Data(), via); err != nil {
+		return err
+	}
+	var deleteObj ap.Reply
+	err = doc.DataTo(&deleteObj)
+	if err != nil {
+		return fmt.Errorf("could not convert document to struct: %w", err)
+	}
+
+	// if this item is in the middle of a reply chain, just make it a tombstone.
+	// The document is rewritten with only what keeps the thread together, so
+	// nothing of the deleted reply (content warning, media, tags, earlier
+	// versions) is left to serve.
+	if len(deleteObj.Replies.Items) > 0 {
+		_, err = repliesCol.Doc(slugDeleteID).Set(ctx, ap.Reply{
+			Id:        deleteObj.Id,
+			Type:      "Tombstone",
+			InReplyTo: deleteObj.InReplyTo,
+			Replies:   deleteObj.Replies,
+			Root:      deleteObj.Root,
+			Via:       deleteObj.Via,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove leaf reply: %v", err)
+		}
+		slog.Info("entombed reply node", "reply", slugDeleteID)
+		return nil
+	}
+
+	// If it's a leaf (reply items is empty), delete this document.
+	// Traverse up the chain using InReplyTo to find tombstones, and remove them
+	// until coming across one that has more than zero replyItems
+	for {
+		_, err := repliesCol.Doc(slugDeleteID).Delete(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to remove leaf reply: %w", err)
+		}
+		slog.Info("deleted leaf node", "reply", slugDeleteID)
+		replyURI, err = url.Parse(deleteObj.InReplyTo.(string))
+		if err != nil {
+			return err
+		}
+		slugDeleteID = Sluggify(*replyURI)
+		_, err = repliesCol.Doc(slugDeleteID).Update(ctx, []firestore.Update{
+			{Path: "Replies.Items", Value: firestore.ArrayRemove(deleteObj.Id)},
+		})
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				return fmt.Errorf("error accessing replies doc: %w", err)
+			}
+			return fmt.Errorf("InReplyTo reference broken: %s", slugDeleteID)
+		}
+		slog.Info("delinked reply from parent", "reply", slugDeleteID)
+
+		doc, err := repliesCol.Doc(slugDeleteID).Get(ctx)
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				return fmt.Errorf("error accessing replies doc: %w", err)
+			}
+			return err
+		}
+		deleteObj = ap.Reply{}
+		err = doc.DataTo(&deleteObj)
+		if err != nil {
+			return fmt.Errorf("could not convert document to struct: %w", err)
+		}
+		if deleteObj.Type != "Tombstone" || len(deleteObj.Replies.Items) > 0 {
+			break
+		}
+	}
+
+	return nil
+}
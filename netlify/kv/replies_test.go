@@ -0,0 +1,29 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/ap"
+)
+
+func TestParentRoot(t *testing.T) {
+	const post = "https://blog.example/posts/hello"
+	tests := []struct {
+		name   string
+		parent ap.Reply
+		want   string
+	}{
+		{"the post", ap.Reply{Id: post}, post},
+		{"a reply to the post", ap.Reply{Id: "https://a.example/1",
+			InReplyTo: post, Root: post}, post},
+		{"a reply stored before roots", ap.Reply{Id: "https://a.example/2",
+			InReplyTo: "https://a.example/1"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parentRoot(&tt.parent, tt.parent.Id); got != tt.want {
+				t.Errorf("parentRoot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
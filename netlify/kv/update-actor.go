@@ -3,6 +3,7 @@ package kv
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"cloud.google.com/go/firestore"
 	"github.com/maxbanister/blog/netlify/ap"
@@ -13,7 +14,7 @@ import (
 
 func UpdateAllActorRefs(actor *ap.Actor) error {
 	actorAt := ap.GetActorAt(actor)
-	fmt.Println("Got profile update for", actorAt)
+	slog.Info("got profile update", "actor", actorAt)
 
 	// check if follower exists, if so update there
 	client, err := GetFirestoreClient()
@@ -22,23 +23,25 @@ func UpdateAllActorRefs(actor *ap.Actor) error {
 	}
 	defer client.Close()
 
-	followersCol := client.Collection("followers")
 	ctx := context.Background()
-	// can't update with a struct using the firestore SDK
-	_, err = followersCol.Doc(actorAt).Update(ctx, []firestore.Update{
-		{Path: "Name", Value: actor.Name},
-		{Path: "PreferredUsername", Value: actor.PreferredUsername},
-		{Path: "Inbox", Value: actor.Inbox},
-		{Path: "Icon", Value: actor.Icon},
-	})
-	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			fmt.Println("actor not in followers")
+	for _, local := range ap.LocalActors {
+		colName := local.FollowersCollection()
+		// can't update with a struct using the firestore SDK
+		_, err = client.Collection(colName).Doc(actorAt).Update(ctx, []firestore.Update{
+			{Path: "Name", Value: actor.Name},
+			{Path: "PreferredUsername", Value: actor.PreferredUsername},
+			{Path: "Inbox", Value: actor.Inbox},
+			{Path: "Icon", Value: actor.Icon},
+		})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				slog.Debug("actor not in collection", "collection", colName)
+			} else {
+				return fmt.Errorf("could not update %s: %w", colName, err)
+			}
 		} else {
-			return fmt.Errorf("could not update followers: %w", err)
+			slog.Info("updated actor", "collection", colName)
 		}
-	} else {
-		fmt.Println("Sucessfully updated actor in followers")
 	}
 
 	bulkWriter := client.BulkWriter(ctx)
@@ -57,7 +60,7 @@ func UpdateAllActorRefs(actor *ap.Actor) error {
 			if err != nil {
 				return fmt.Errorf("document iterator error: %w", err)
 			}
-			fmt.Printf("Updating document ref %s/%s\n", colName, doc.Ref.ID)
+			slog.Debug("updating document", "collection", colName, "doc", doc.Ref.ID)
 			_, err = bulkWriter.Update(doc.Ref, []firestore.Update{
 				{Path: "Actor", Value: &actor},
 			})
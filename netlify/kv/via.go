@@ -0,0 +1,26 @@
+package kv
+
+import (
+	"fmt"
+
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+// Fails unless a stored document arrived the way something changing it did,
+// so a webmention can't overwrite or remove a reply, like or share that came
+// over ActivityPub, nor the other way round
+func checkVia(data map[string]any, via string) error {
+	stored, _ := data["Via"].(string)
+	if stored != via {
+		return fmt.Errorf("%w: %s did not arrive by %s", ErrForbidden,
+			data["Id"], viaName(via))
+	}
+	return nil
+}
+
+func viaName(via string) string {
+	if via == "" {
+		return "ActivityPub"
+	}
+	return via
+}
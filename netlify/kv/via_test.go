@@ -0,0 +1,36 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	. "github.com/maxbanister/blog/netlify/util"
+)
+
+func TestCheckVia(t *testing.T) {
+	apReply := map[string]any{"Id": "https://mastodon.example/notes/1"}
+	mention := map[string]any{"Id": "https://alice.example/reply", "Via": ap.ViaWebmention}
+	tests := []struct {
+		name   string
+		stored map[string]any
+		via    string
+		ok     bool
+	}{
+		{"webmention changing a webmention", mention, ap.ViaWebmention, true},
+		{"activitypub changing activitypub", apReply, "", true},
+		{"webmention changing activitypub", apReply, ap.ViaWebmention, false},
+		{"activitypub changing a webmention", mention, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVia(tt.stored, tt.via)
+			if tt.ok && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrForbidden) {
+				t.Errorf("got %v, want forbidden", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package logging sets up log/slog for the functions: JSON lines on stdout,
+// where Netlify collects them, at the level set by log_level, tagged with the
+// ID of the request being handled and with credentials redacted.
+//
+// Code logs through slog's default logger. A function instance handles one
+// invocation at a time, so Start swaps the default for one carrying the new
+// request's ID.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/maxbanister/blog/netlify/config"
+)
+
+// RequestIDHeader carries the request ID when one function calls another, so
+// the logs of both share it
+const RequestIDHeader = "X-Request-Id"
+
+// set by Netlify on each request it routes to a function
+const netlifyRequestIDHeader = "x-nf-request-id"
+
+const redacted = "[REDACTED]"
+
+// attributes and headers whose values are never logged, by lowercase name,
+// besides those naming a key, secret or token
+var sensitiveNames = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"signature":           true,
+}
+
+var base = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	Level:       level(),
+	ReplaceAttr: redact,
+}))
+
+var requestID string
+
+func init() {
+	slog.SetDefault(base)
+}
+
+func level() slog.Level {
+	// validated when the config loads
+	l, _ := config.Site.Level()
+	return l
+}
+
+// Start tags what's logged from here on with the ID of the request whose
+// headers are given: the one a calling function passed along, else
+// Netlify's, else a new one. It returns the ID.
+func Start(headers map[string]string) string {
+	requestID = headerValue(headers, RequestIDHeader)
+	if requestID == "" {
+		requestID = headerValue(headers, netlifyRequestIDHeader)
+	}
+	if requestID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		requestID = hex.EncodeToString(b)
+	}
+	slog.SetDefault(base.With("request_id", requestID))
+	return requestID
+}
+
+// RequestID is the ID of the request being handled, to pass on to the
+// functions it calls
+func RequestID() string {
+	return requestID
+}
+
+// Netlify lowercases the headers it hands Go functions, but not those of
+// requests made in tests or netlify dev
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	return sensitiveNames[name] || strings.Contains(name, "secret") ||
+		strings.Contains(name, "token") || strings.HasSuffix(name, "key")
+}
+
+// Replaces the values of sensitive attributes, of sensitive entries in
+// logged header maps, and of anything holding a private key
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch v := a.Value.Any().(type) {
+	case string:
+		if strings.Contains(v, "PRIVATE KEY") {
+			return slog.String(a.Key, redacted)
+		}
+	case map[string]string:
+		return slog.Any(a.Key, RedactHeaders(v))
+	}
+	return a
+}
+
+// RedactHeaders copies headers with the values of sensitive ones replaced
+func RedactHeaders(headers map[string]string) map[string]string {
+	safe := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if isSensitive(k) {
+			v = redacted
+		}
+		safe[k] = v
+	}
+	return safe
+}
@@ -0,0 +1,379 @@
+// Package mf2 parses the parts of microformats2 (https://microformats.org/wiki/microformats2-parsing)
+// that webmentions use: h-* items with p-, u-, dt- and e- properties, nested
+// items, and implied name, photo and url.
+package mf2
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Item is an h-* object. Property values are strings, *HTML for e-*
+// properties, or *Item for nested items.
+type Item struct {
+	Type       []string
+	Properties map[string][]any
+	Children   []*Item
+	// for an item nested under a property, its plain value (the name of a
+	// p-author h-card, the url of a u-in-reply-to h-cite)
+	Value string
+
+	// which kinds of property were given explicitly, which rules out
+	// implying others
+	used      map[string]bool
+	hasNested bool
+}
+
+type HTML struct {
+	HTML  string
+	Value string
+}
+
+var rootClass = regexp.MustCompile(`^h(-[a-z0-9]+)+$`)
+var propClass = regexp.MustCompile(`^(p|u|dt|e)(-[a-z0-9]+)+$`)
+
+type parser struct {
+	base *url.URL
+}
+
+// Parse returns the top-level items in an HTML document fetched from base
+func Parse(r io.Reader, base *url.URL) ([]*Item, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{base: base}
+	if baseHref := findBaseHref(doc); baseHref != "" {
+		if u, err := base.Parse(baseHref); err == nil {
+			p.base = u
+		}
+	}
+
+	var items []*Item
+	p.walk(doc, nil, &items)
+	return items, nil
+}
+
+// Links returns every URL the document links to or embeds, resolved
+func Links(r io.Reader, base *url.URL) ([]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" && attr.Key != "src" {
+					continue
+				}
+				if u, err := base.Parse(strings.TrimSpace(attr.Val)); err == nil {
+					links = append(links, u.String())
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	return links, nil
+}
+
+// Get returns the first value of a property as a string: the plain value of
+// nested items and HTML
+func (item *Item) Get(prop string) string {
+	values := item.Properties[prop]
+	if len(values) == 0 {
+		return ""
+	}
+	return valueString(values[0])
+}
+
+// Strings returns the plain values of a property
+func (item *Item) Strings(prop string) []string {
+	var strs []string
+	for _, v := range item.Properties[prop] {
+		strs = append(strs, valueString(v))
+	}
+	return strs
+}
+
+func (item *Item) HasType(typ string) bool {
+	return slices.Contains(item.Type, typ)
+}
+
+func valueString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case *HTML:
+		return v.Value
+	case *Item:
+		return v.Value
+	}
+	return ""
+}
+
+func (p *parser) walk(n *html.Node, parent *Item, topLevel *[]*Item) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		p.element(c, parent, topLevel)
+	}
+}
+
+func (p *parser) element(n *html.Node, parent *Item, topLevel *[]*Item) {
+	var types, props []string
+	for _, class := range strings.Fields(attr(n, "class")) {
+		if rootClass.MatchString(class) {
+			types = append(types, class)
+		} else if propClass.MatchString(class) {
+			props = append(props, class)
+		}
+	}
+
+	if len(types) == 0 {
+		if parent != nil {
+			for _, prop := range props {
+				prefix, name, _ := strings.Cut(prop, "-")
+				parent.add(name, p.propertyValue(n, prefix))
+				parent.used[prefix] = true
+			}
+		}
+		p.walk(n, parent, topLevel)
+		return
+	}
+
+	item := &Item{
+		Type:       types,
+		Properties: make(map[string][]any),
+		used:       make(map[string]bool),
+	}
+	p.walk(n, item, topLevel)
+	p.implyProperties(n, item)
+
+	if parent != nil {
+		parent.hasNested = true
+	}
+	switch {
+	case parent != nil && len(props) > 0:
+		for _, prop := range props {
+			prefix, name, _ := strings.Cut(prop, "-")
+			nested := *item
+			switch prefix {
+			case "u":
+				nested.Value = nested.Get("url")
+			case "e":
+				nested.Value = textContent(n)
+			default:
+				nested.Value = nested.Get("name")
+			}
+			if nested.Value == "" {
+				nested.Value = valueString(p.propertyValue(n, prefix))
+			}
+			parent.add(name, &nested)
+			parent.used[prefix] = true
+		}
+	case parent != nil:
+		parent.Children = append(parent.Children, item)
+	default:
+		*topLevel = append(*topLevel, item)
+	}
+}
+
+func (item *Item) add(name string, value any) {
+	item.Properties[name] = append(item.Properties[name], value)
+}
+
+func (p *parser) propertyValue(n *html.Node, prefix string) any {
+	switch prefix {
+	case "u":
+		for _, key := range urlAttrs(n) {
+			if v, ok := attrOK(n, key); ok {
+				return p.resolve(v)
+			}
+		}
+		if v, ok := attrOK(n, "value"); ok {
+			return p.resolve(v)
+		}
+		return p.resolve(textContent(n))
+	case "dt":
+		switch n.DataAtom {
+		case atom.Time, atom.Ins, atom.Del:
+			if v, ok := attrOK(n, "datetime"); ok {
+				return v
+			}
+		case atom.Abbr:
+			if v, ok := attrOK(n, "title"); ok {
+				return v
+			}
+		case atom.Data, atom.Input:
+			if v, ok := attrOK(n, "value"); ok {
+				return v
+			}
+		}
+		return textContent(n)
+	case "e":
+		var buf strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			html.Render(&buf, c)
+		}
+		return &HTML{HTML: strings.TrimSpace(buf.String()), Value: textContent(n)}
+	}
+	// p-
+	switch n.DataAtom {
+	case atom.Abbr, atom.Link:
+		if v, ok := attrOK(n, "title"); ok {
+			return v
+		}
+	case atom.Data, atom.Input:
+		if v, ok := attrOK(n, "value"); ok {
+			return v
+		}
+	case atom.Img, atom.Area:
+		if v, ok := attrOK(n, "alt"); ok {
+			return v
+		}
+	}
+	return textContent(n)
+}
+
+func urlAttrs(n *html.Node) []string {
+	switch n.DataAtom {
+	case atom.A, atom.Area, atom.Link:
+		return []string{"href"}
+	case atom.Img, atom.Audio, atom.Source, atom.Iframe:
+		return []string{"src"}
+	case atom.Video:
+		return []string{"src", "poster"}
+	case atom.Object:
+		return []string{"data"}
+	}
+	return nil
+}
+
+// Fills in name, photo and url from the element itself when the item
+// doesn't give them explicitly
+func (p *parser) implyProperties(n *html.Node, item *Item) {
+	if _, ok := item.Properties["name"]; !ok &&
+		!item.used["p"] && !item.used["e"] && !item.hasNested {
+		name := ""
+		switch n.DataAtom {
+		case atom.Img, atom.Area:
+			name = attr(n, "alt")
+		case atom.Abbr:
+			name = attr(n, "title")
+		}
+		if name == "" {
+			if only := onlyChild(n, atom.Img); only != nil {
+				name = attr(only, "alt")
+			}
+		}
+		if name == "" {
+			name = textContent(n)
+		}
+		item.add("name", name)
+	}
+	if _, ok := item.Properties["photo"]; !ok && !item.used["u"] && !item.hasNested {
+		if n.DataAtom == atom.Img {
+			item.add("photo", p.resolve(attr(n, "src")))
+		} else if img := onlyChild(n, atom.Img); img != nil {
+			item.add("photo", p.resolve(attr(img, "src")))
+		}
+	}
+	if _, ok := item.Properties["url"]; !ok && !item.used["u"] && !item.hasNested {
+		if n.DataAtom == atom.A {
+			if href, ok := attrOK(n, "href"); ok {
+				item.add("url", p.resolve(href))
+			}
+		} else if a := onlyChild(n, atom.A); a != nil {
+			if href, ok := attrOK(a, "href"); ok {
+				item.add("url", p.resolve(href))
+			}
+		}
+	}
+}
+
+func onlyChild(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if found != nil || c.DataAtom != a {
+			return nil
+		}
+		found = c
+	}
+	return found
+}
+
+func (p *parser) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || p.base == nil {
+		return ref
+	}
+	u, err := p.base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func findBaseHref(n *html.Node) string {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+		return attr(n, "href")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if href := findBaseHref(c); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+func attr(n *html.Node, key string) string {
+	v, _ := attrOK(n, key)
+	return v
+}
+
+func attrOK(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// Text of an element with whitespace collapsed, images replaced by their alt
+// text, and scripts and styles left out
+func textContent(n *html.Node) string {
+	var text strings.Builder
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.DataAtom == atom.Img:
+			text.WriteString(attr(n, "alt"))
+		case n.Type == html.ElementNode &&
+			(n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+		default:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				visit(c)
+			}
+		}
+	}
+	visit(n)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
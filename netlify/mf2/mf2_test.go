@@ -0,0 +1,179 @@
+package mf2
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func parseFixture(t *testing.T, name, pageURL string) []*Item {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	base, _ := url.Parse(pageURL)
+	items, err := Parse(f, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func onlyItem(t *testing.T, items []*Item, typ string) *Item {
+	t.Helper()
+	if len(items) != 1 || !items[0].HasType(typ) {
+		t.Fatalf("want a single %s, got %d items", typ, len(items))
+	}
+	return items[0]
+}
+
+func TestReplyWithNestedCard(t *testing.T) {
+	items := parseFixture(t, "reply.html", "https://alice.example/2024/01/reply")
+	entry := onlyItem(t, items, "h-entry")
+
+	if got := entry.Strings("in-reply-to"); !slices.Equal(got,
+		[]string{"https://maxbanister.com/posts/hello/"}) {
+		t.Errorf("in-reply-to = %q", got)
+	}
+	if got := entry.Get("url"); got != "https://alice.example/2024/01/reply" {
+		t.Errorf("url = %q", got)
+	}
+	if got := entry.Get("published"); got != "2024-01-02T03:04:05+01:00" {
+		t.Errorf("published = %q", got)
+	}
+
+	content, ok := entry.Properties["content"][0].(*HTML)
+	if !ok {
+		t.Fatalf("content is %T, want *HTML", entry.Properties["content"][0])
+	}
+	if content.Value != "Great post!" {
+		t.Errorf("content value = %q", content.Value)
+	}
+	if want := "<p>Great <em>post</em>!</p>\n\t\t<script>alert(1)</script>"; content.HTML != want {
+		t.Errorf("content HTML = %q, want %q", content.HTML, want)
+	}
+
+	author, ok := entry.Properties["author"][0].(*Item)
+	if !ok || !author.HasType("h-card") {
+		t.Fatalf("author = %#v, want an h-card", entry.Properties["author"])
+	}
+	if got := author.Get("name"); got != "Alice Example" {
+		t.Errorf("author name = %q", got)
+	}
+	if got := author.Get("url"); got != "https://alice.example/" {
+		t.Errorf("author url = %q", got)
+	}
+	if got := author.Get("photo"); got != "https://alice.example/me.jpg" {
+		t.Errorf("author photo = %q", got)
+	}
+	// a p-author's plain value is its name
+	if got := entry.Get("author"); got != "Alice Example" {
+		t.Errorf("author value = %q", got)
+	}
+	// the card is a property, not a child
+	if len(entry.Children) != 0 {
+		t.Errorf("entry has %d children", len(entry.Children))
+	}
+}
+
+func TestReplyWithCite(t *testing.T) {
+	items := parseFixture(t, "cite.html", "https://bob.example/notes/1")
+	entry := onlyItem(t, items, "h-entry")
+
+	// a u-in-reply-to h-cite's plain value is its url
+	if got := entry.Strings("in-reply-to"); !slices.Equal(got,
+		[]string{"https://maxbanister.com/posts/hello/"}) {
+		t.Errorf("in-reply-to = %q", got)
+	}
+	cite := entry.Properties["in-reply-to"][0].(*Item)
+	if got := cite.Get("author"); got != "Max" {
+		t.Errorf("cite author = %q", got)
+	}
+
+	author := entry.Properties["author"][0].(*Item)
+	if got := author.Get("name"); got != "Bob" {
+		t.Errorf("author name = %q", got)
+	}
+	if got := author.Get("url"); got != "https://bob.example/" {
+		t.Errorf("implied author url = %q", got)
+	}
+	if got := entry.Get("name"); got != "Replying with a cite" {
+		t.Errorf("name = %q", got)
+	}
+	if got := entry.Get("content"); got != "Replying with a cite" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestFeedWithBase(t *testing.T) {
+	items := parseFixture(t, "feed.html", "https://carol.example/")
+	feed := onlyItem(t, items, "h-feed")
+	if len(feed.Children) != 2 {
+		t.Fatalf("feed has %d entries, want 2", len(feed.Children))
+	}
+	like, repost := feed.Children[0], feed.Children[1]
+	if got := like.Get("url"); got != "https://carol.example/blog/one" {
+		t.Errorf("url resolved against <base> = %q", got)
+	}
+	if got := like.Get("like-of"); got != "https://maxbanister.com/posts/hello/" {
+		t.Errorf("like-of = %q", got)
+	}
+	// a plain p-author is just text
+	if got, ok := like.Properties["author"][0].(string); !ok || got != "Carol" {
+		t.Errorf("author = %#v", like.Properties["author"])
+	}
+	if got := repost.Get("repost-of"); got != "https://maxbanister.com/posts/other/" {
+		t.Errorf("repost-of = %q", got)
+	}
+}
+
+func TestImpliedProperties(t *testing.T) {
+	items := parseFixture(t, "implied.html", "https://dave.example/")
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	dave, erin := items[0], items[1]
+	if got := dave.Get("name"); got != "Dave" {
+		t.Errorf("name from img alt = %q", got)
+	}
+	if got := dave.Get("photo"); got != "https://dave.example/dave.png" {
+		t.Errorf("photo = %q", got)
+	}
+	if got := dave.Get("url"); got != "https://dave.example/" {
+		t.Errorf("url = %q", got)
+	}
+	if got := erin.Get("name"); got != "Erin Example" {
+		t.Errorf("name from abbr title = %q", got)
+	}
+	if _, ok := erin.Properties["url"]; ok {
+		t.Errorf("url implied without a link: %q", erin.Get("url"))
+	}
+}
+
+func TestLinks(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "feed.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	base, _ := url.Parse("https://carol.example/blog/")
+	links, err := Links(f, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"https://carol.example/blog/one",
+		"https://maxbanister.com/posts/hello/",
+		"https://maxbanister.com/posts/other/",
+		"https://carol.example/blog/pic.png",
+		"https://carol.example/blog/#top",
+	} {
+		if !slices.Contains(links, want) {
+			t.Errorf("links %q missing %q", links, want)
+		}
+	}
+}
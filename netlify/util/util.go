@@ -1,32 +1,28 @@
 package util
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/maxbanister/blog/netlify/config"
 )
 
 var ErrUnauthorized = errors.New(http.StatusText(http.StatusUnauthorized))
 var ErrNotImplemented = errors.New(http.StatusText(http.StatusNotImplemented))
 var ErrBadRequest = errors.New(http.StatusText(http.StatusBadRequest))
 var ErrAlreadyDone = errors.New("already done")
+var ErrForbidden = errors.New(http.StatusText(http.StatusForbidden))
 
 type LambdaRequest = events.APIGatewayProxyRequest
 type LambdaResponse = events.APIGatewayProxyResponse
 
 func GetHostSite() string {
-	host := os.Getenv("URL")
-	// hack to get to work in dev
-	if host == "" || strings.Contains(host, "localhost") {
-		return "https://maxbanister.com"
-	} else {
-		return host
-	}
+	return config.Site.BaseURL
 }
 
 func Sluggify(uri url.URL) string {
@@ -52,6 +48,8 @@ func GetLambdaResp(err error) (*LambdaResponse, error) {
 	var code int
 	if errors.Is(err, ErrUnauthorized) {
 		code = http.StatusUnauthorized
+	} else if errors.Is(err, ErrForbidden) {
+		code = http.StatusForbidden
 	} else if errors.Is(err, ErrBadRequest) {
 		code = http.StatusBadRequest
 	} else if errors.Is(err, ErrNotImplemented) {
@@ -68,7 +66,13 @@ func GetLambdaResp(err error) (*LambdaResponse, error) {
 	if err != nil {
 		errMsg = err.Error()
 	}
-	fmt.Println(code, err)
+	level := slog.LevelInfo
+	if code >= 500 {
+		level = slog.LevelError
+	} else if code >= 400 {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "responding", "status", code, "err", err)
 	return &events.APIGatewayProxyResponse{
 		StatusCode: code,
 		Body:       errMsg,
@@ -77,6 +81,7 @@ func GetLambdaResp(err error) (*LambdaResponse, error) {
 
 // Simpler version of the above that only returns status code 500
 func GetErrorResp(err error) (*LambdaResponse, error) {
+	slog.Error("responding", "status", 500, "err", err)
 	return &events.APIGatewayProxyResponse{
 		StatusCode: 500,
 		Body:       err.Error(),
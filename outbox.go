@@ -1,6 +1,25 @@
 package blog
 
-import _ "embed"
+import (
+	"embed"
+	"fmt"
+)
 
-//go:embed public/ap/outbox.json
-var OutboxJSON []byte
+// public/ap always has tracked files, so the package builds before
+// scripts/gen_outbox has written the outbox into it
+//
+//go:embed public/ap
+var publicAP embed.FS
+
+// OutboxJSON returns the post activities rendered at build time by
+// scripts/gen_outbox, which the Netlify build runs before the functions are
+// compiled. This is only the source of our posts; /ap/outbox itself is
+// served by the outbox function.
+func OutboxJSON() ([]byte, error) {
+	data, err := publicAP.ReadFile("public/ap/outbox.json")
+	if err != nil {
+		return nil, fmt.Errorf("no outbox, run scripts/gen_outbox before "+
+			"building the functions: %w", err)
+	}
+	return data, nil
+}
@@ -3,15 +3,29 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/kv"
+	. "github.com/maxbanister/blog/netlify/util"
 )
 
 func main() {
-	userURL := os.Args[1]
+	actorName := flag.String("actor", "", "username of the local actor to send as "+
+		"(default "+ap.DefaultActor().Username+")")
+	flag.Parse()
+	from := ap.DefaultActor()
+	if *actorName != "" {
+		from = ap.FindLocalActor(*actorName)
+		if from == nil {
+			fmt.Println("no local actor named", *actorName)
+			return
+		}
+	}
+	userURL := flag.Arg(0)
 	fmt.Println("Sending message to", userURL)
 
 	req, err := http.NewRequest("GET", userURL, bytes.NewBuffer([]byte{}))
@@ -34,60 +48,41 @@ func main() {
 		return
 	}
 
-	payload := `{
-			"@context": "https://www.w3.org/ns/activitystreams",
-			"id": "https://maxbanister.com/posts/post-3/#create",
-			"type": "Create",
-			"actor": "https://maxbanister.com/ap/user/max",
-			"to": [
-				"https://www.w3.org/ns/activitystreams#Public"
-			],
-			"cc": [
-				"https://maxbanister.com/ap/followers"
-			],
-			"published": "2023-03-15T11:00:00-07:00",
-			"object": {
-				"@context": "https://www.w3.org/ns/activitystreams",
-				"id": "https://maxbanister.com/posts/post-3/",
-				"type": "Note",
-				"content": "Post 3\nOccaecat aliqua consequat laborum ut ex aute aliqua culpa quis irure esse magna dolore quis. Proident fugiat labore eu laboris officia Lorem enim. Ipsum occaecat cillum ut tempor id sint aliqua incididunt nisi incididunt reprehenderit. Voluptate ad minim … https://maxbanister.com/posts/post-3/",
-				"url": "https://maxbanister.com/posts/post-3/",
-				"attributedTo": "https://maxbanister.com/ap/user/max",
-				"to": [
-					"https://www.w3.org/ns/activitystreams#Public"
-				],
-				"cc": [],
-				"published": "2023-03-15T11:00:00-07:00",
-				"replies": "https://maxbanister.com/posts/post-3/replies",
-				"likes": "https://maxbanister.com/posts/post-3/likes",
-				"shares": "https://maxbanister.com/posts/post-3/shares",
-				"tag": [
-					{
-						"Type": "Hashtag",
-						"Href": "https://maxbanister.com/tags/red",
-						"Name": "#red"
-					}, 
-					{
-						"Type": "Hashtag",
-						"Href": "https://maxbanister.com/tags/green",
-						"Name": "#green"
-					}, 
-					{
-						"Type": "Hashtag",
-						"Href": "https://maxbanister.com/tags/blue",
-						"Name": "#blue"
-					}
-				]
-			}
-		}`
+	host := GetHostSite()
+	postURL := host + "/posts/post-3/"
+	var tags []map[string]string
+	for _, tag := range []string{"red", "green", "blue"} {
+		tags = append(tags, map[string]string{
+			"type": "Hashtag",
+			"href": host + "/tags/" + tag,
+			"name": "#" + tag,
+		})
+	}
+	create := ap.NewCreate(from, map[string]any{
+		"id":        postURL,
+		"type":      "Note",
+		"content":   "Post 3\nOccaecat aliqua consequat laborum ut ex aute aliqua culpa quis irure esse magna dolore quis. Proident fugiat labore eu laboris officia Lorem enim. Ipsum occaecat cillum ut tempor id sint aliqua incididunt nisi incididunt reprehenderit. Voluptate ad minim … " + postURL,
+		"url":       postURL,
+		"published": "2023-03-15T11:00:00-07:00",
+		"replies":   postURL + "replies",
+		"likes":     postURL + "likes",
+		"shares":    postURL + "shares",
+		"tag":       tags,
+	})
+	payload, err := create.Payload()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
-	priv_key_contents, err := os.ReadFile("../../private.pem")
+	keyFile := "../../" + from.PrivateKeyFile()
+	priv_key_contents, err := os.ReadFile(keyFile)
 	if err != nil {
-		fmt.Println("could not find and read private.pem", err.Error())
+		fmt.Println("could not find and read", keyFile, err.Error())
 		return
 	}
 
-	os.Setenv("AP_PRIVATE_KEY", string(priv_key_contents))
+	os.Setenv(from.SigningKey().PrivateKeyEnv, string(priv_key_contents))
 
 	err = ap.SendActivity(payload, &actor)
 	if err != nil {
@@ -95,7 +90,12 @@ func main() {
 		return
 	}
 
-	os.Unsetenv("AP_PRIVATE_KEY")
+	os.Unsetenv(from.SigningKey().PrivateKeyEnv)
+
+	err = kv.SaveActivity(create, payload)
+	if err != nil {
+		fmt.Println("warning: could not save activity:", err.Error())
+	}
 
 	fmt.Println("Successfully sent message")
 }
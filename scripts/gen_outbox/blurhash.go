@@ -0,0 +1,126 @@
+package main
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Sampling every pixel of a photo is slow and doesn't change the result
+const blurhashSamples = 64
+
+// Encodes img as a BlurHash (https://blurha.sh) with the given number of
+// horizontal and vertical components, which Mastodon shows while the real
+// image loads
+func encodeBlurhash(img image.Image, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+	stepX := max(1, width/blurhashSamples)
+	stepY := max(1, height/blurhashSamples)
+
+	// convert the sampled pixels to linear RGB once up front
+	type rgb struct{ r, g, b float64 }
+	var samples [][]rgb
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		var row []rgb
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			row = append(row, rgb{
+				sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8),
+			})
+		}
+		samples = append(samples, row)
+	}
+	rows, cols := len(samples), len(samples[0])
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var f [3]float64
+			for y, row := range samples {
+				for x, px := range row {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(cols)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(rows))
+					f[0] += basis * px.r
+					f[1] += basis * px.g
+					f[2] += basis * px.b
+				}
+			}
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			scale := normalisation / float64(rows*cols)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale,
+				f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]),
+				math.Abs(f[2]))
+		}
+		quantisedMax := clampInt(int(math.Floor(actualMax*166-0.5)), 0, 82)
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dcValue := linearToSRGB(dc[0])<<16 + linearToSRGB(dc[1])<<8 +
+		linearToSRGB(dc[2])
+	hash.WriteString(encode83(dcValue, 4))
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return clampInt(int(math.Floor(signPow(v/maxValue, 0.5)*9+9.5)), 0, 18)
+		}
+		hash.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	var out strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		out.WriteByte(base83Chars[digit])
+	}
+	return out.String()
+}
+
+func sRGBToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(hi, v))
+}
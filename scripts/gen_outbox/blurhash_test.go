@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solidImage(width, height int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	return img
+}
+
+// A gradient with alternating blue, so that every AC component is non-zero
+func patternImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			b := uint8(32)
+			if (x+y)%2 == 1 {
+				b = 128
+			}
+			img.Set(x, y, color.RGBA{uint8(x * 255 / (width - 1)),
+				uint8(y * 255 / (height - 1)), b, 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurhash(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	tests := []struct {
+		name         string
+		img          image.Image
+		xComp, yComp int
+		want         string
+	}{
+		// the reference implementation's hash for a solid red image
+		{"solid red", solidImage(32, 32, red), 1, 1, "00TI:j"},
+		// large enough to be sampled rather than read pixel by pixel
+		{"sampled solid red", solidImage(640, 480, red), 1, 1, "00TI:j"},
+		{"solid black", solidImage(8, 8, color.Black), 1, 1, "000000"},
+		// from the reference TypeScript encoder, given the same pixels
+		{"pattern", patternImage(8, 6), 4, 3, "LyI5eK3AfQxtz3NJfQnReXf7fQf7"},
+		{"empty", image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeBlurhash(tt.img, tt.xComp, tt.yComp)
+			if got != tt.want {
+				t.Errorf("encodeBlurhash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeBlurhashLength(t *testing.T) {
+	// a size flag, the AC maximum, 4 characters of DC and 2 per AC component
+	img := solidImage(16, 16, color.RGBA{10, 120, 200, 255})
+	got := encodeBlurhash(img, 4, 3)
+	if len(got) != 1+1+4+2*(4*3-1) {
+		t.Fatalf("encodeBlurhash() = %q, wrong length %d", got, len(got))
+	}
+	// (4-1) + (3-1)*9 = 21, which base 83 writes as "L"
+	if got[0] != 'L' {
+		t.Errorf("size flag = %q, want %q", got[0], 'L')
+	}
+}
+
+func TestEncode83(t *testing.T) {
+	tests := []struct {
+		value, length int
+		want          string
+	}{
+		{0, 1, "0"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{0xFF0000, 4, "TI:j"},
+	}
+	for _, tt := range tests {
+		if got := encode83(tt.value, tt.length); got != tt.want {
+			t.Errorf("encode83(%d, %d) = %q, want %q", tt.value, tt.length,
+				got, tt.want)
+		}
+	}
+}
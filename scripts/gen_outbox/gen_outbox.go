@@ -0,0 +1,307 @@
+// Generates public/ap/outbox.json from the posts' front matter and their
+// rendered HTML. Run it after `hugo` has built the site:
+//
+//	go run ./scripts/gen_outbox
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/maxbanister/blog/netlify/ap"
+)
+
+const dateLayout = "2006-01-02T15:04:05-07:00"
+
+// Posts with more text than fits in a Note go out as Articles
+const articleThreshold = ap.NoteCharLimit
+
+type outboxCollection struct {
+	Context      any            `json:"@context"`
+	Id           string         `json:"id"`
+	Summary      string         `json:"summary"`
+	Type         string         `json:"type"`
+	TotalItems   int            `json:"totalItems"`
+	OrderedItems []*ap.Activity `json:"orderedItems"`
+}
+
+func main() {
+	siteDir := flag.String("site", ".", "root directory of the Hugo site")
+	publicDir := flag.String("public", "", "hugo's output directory "+
+		"(default <site>/public)")
+	flag.Parse()
+	if *publicDir == "" {
+		*publicDir = filepath.Join(*siteDir, "public")
+	}
+
+	cfg, err := readSiteConfig(*siteDir)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	posts, err := loadPosts(*siteDir, *publicDir, cfg)
+	if err != nil {
+		fmt.Println("could not load posts:", err.Error())
+		os.Exit(1)
+	}
+	// newest first, like Hugo's default page order
+	slices.SortFunc(posts, func(a, b *post) int {
+		return b.date.Compare(a.date)
+	})
+
+	host := strings.TrimSuffix(cfg.BaseURL, "/")
+	outbox := outboxCollection{
+		Context: ap.ObjectContext,
+		Id:      host + "/ap/outbox",
+		Summary: "Recent posts from " + cfg.Title,
+		Type:    "OrderedCollection",
+	}
+	var errs []error
+	for _, p := range posts {
+		activity, err := buildActivity(p, host, *publicDir, cfg)
+		if err == nil {
+			err = validateActivity(activity, host)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.sourcePath, err))
+			continue
+		}
+		outbox.OrderedItems = append(outbox.OrderedItems, activity)
+	}
+	if len(errs) > 0 {
+		fmt.Println("invalid outbox items:")
+		fmt.Println(errors.Join(errs...))
+		os.Exit(1)
+	}
+	outbox.TotalItems = len(outbox.OrderedItems)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(outbox); err != nil {
+		fmt.Println("could not encode outbox:", err.Error())
+		os.Exit(1)
+	}
+	outboxJSON := buf.Bytes()
+	// make sure what we wrote is something our own inbox code could read
+	if _, err := ap.CompactJSON(outboxJSON); err != nil {
+		fmt.Println("generated outbox is not valid JSON:", err.Error())
+		os.Exit(1)
+	}
+	outPath := filepath.Join(*publicDir, "ap", "outbox.json")
+	err = os.MkdirAll(filepath.Dir(outPath), 0755)
+	if err == nil {
+		err = os.WriteFile(outPath, outboxJSON, 0644)
+	}
+	if err != nil {
+		fmt.Println("could not write outbox:", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote %d activities to %s\n", outbox.TotalItems, outPath)
+}
+
+func buildActivity(p *post, host, publicDir string, cfg *siteConfig) (*ap.Activity, error) {
+	permalink := host + "/posts/" + p.slug + "/"
+	actor := ap.DefaultActor()
+	if p.Actor != "" {
+		actor = ap.FindLocalActor(p.Actor)
+		if actor == nil {
+			return nil, fmt.Errorf("no actor named %q", p.Actor)
+		}
+	}
+	actorID := host + actor.Path()
+	activity := &ap.Activity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Actor:   actorID,
+		To:      []string{ap.PublicAddress},
+		Cc:      []string{actor.FollowersID()},
+	}
+
+	// activity IDs are served by the activities function
+	activityType := "create"
+	if p.lastmod.Format(time.DateOnly) > p.date.Format(time.DateOnly) {
+		activityType = "update"
+	}
+	if p.isExpired() {
+		activityType = "delete"
+	}
+	activity.Id = host + "/ap/activities/" + activityType + "-" + p.slug
+	if activityType == "update" {
+		activity.Id += fmt.Sprintf("-%d", p.lastmod.Unix())
+	}
+	activity.Type = strings.ToUpper(activityType[:1]) + activityType[1:]
+
+	if activityType == "delete" {
+		activity.Object = &ap.Object{
+			Context: "https://www.w3.org/ns/activitystreams",
+			Id:      permalink,
+			Type:    "Tombstone",
+		}
+		return activity, nil
+	}
+
+	attachments, err := buildAttachments(p, host, publicDir)
+	if err != nil {
+		return nil, err
+	}
+	activity.Context = ap.ObjectContext
+	activity.Published = p.date.Format(dateLayout)
+	object := &ap.Object{
+		Context:      ap.ObjectContext,
+		Id:           permalink,
+		Type:         "Note",
+		URL:          permalink,
+		AttributedTo: actorID,
+		To:           []string{ap.PublicAddress},
+		Published:    p.date.Format(dateLayout),
+		// served by reply-service; embedding the collection tells other
+		// servers they can page through it to backfill the thread
+		Replies: map[string]any{
+			"id":    permalink + "replies",
+			"type":  "OrderedCollection",
+			"first": permalink + "replies?page=1",
+		},
+		Likes:      permalink + "likes",
+		Shares:     permalink + "shares",
+		Attachment: attachments,
+		Tag:        hashtags(p, host),
+	}
+	if activityType == "update" {
+		object.Updated = p.lastmod.Format(dateLayout)
+	}
+
+	// readers whose software can't show an Article get a Note preview of it
+	// at delivery time, see ap.NotePreview
+	object.Type = p.ActivityType
+	if object.Type == "" {
+		object.Type = "Note"
+		if utf8.RuneCountInString(htmlToText(p.contentHTML)) > articleThreshold {
+			object.Type = "Article"
+		}
+	}
+	if object.Type == "Article" {
+		object.Name = p.Title
+		object.Summary = html.EscapeString(postSummary(p))
+		object.Content = p.contentHTML
+	} else {
+		// short enough to send whole
+		object.Content = "<p>" + html.EscapeString(p.Title) + "</p>" +
+			p.contentHTML + ap.HashtagLinks(object.Tag)
+	}
+	object.ContentMap = map[string]string{
+		language(cfg.LanguageCode): object.Content,
+	}
+	activity.Object = object
+
+	return activity, nil
+}
+
+func hashtags(p *post, host string) []ap.Tag {
+	var tags []ap.Tag
+	for _, tag := range p.Tags {
+		tags = append(tags, ap.Tag{
+			Type: "Hashtag",
+			Href: host + "/tags/" + url.PathEscape(strings.ToLower(tag)) + "/",
+			Name: "#" + hashtagName(tag),
+		})
+	}
+	return tags
+}
+
+// Hashtags can't contain spaces or punctuation
+func hashtagName(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, tag)
+}
+
+// The front matter summary, or else the opening words of the post like Hugo's
+// automatic summary
+func postSummary(p *post) string {
+	if p.Summary != "" {
+		return strings.Join(strings.Fields(p.Summary), " ")
+	}
+	words := strings.Fields(htmlToText(p.contentHTML))
+	if len(words) > 70 {
+		return strings.Join(words[:70], " ") + " …"
+	}
+	return strings.Join(words, " ")
+}
+
+// "en-us" -> "en", as contentMap keys are bare language tags
+func language(languageCode string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	if lang == "" {
+		return "und"
+	}
+	return lang
+}
+
+// Catches the mistakes that used to slip through the JSON template
+func validateActivity(activity *ap.Activity, host string) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	isOurURL := func(s string) bool {
+		return strings.HasPrefix(s, host+"/")
+	}
+
+	check(isOurURL(activity.Id), "bad activity id %q", activity.Id)
+	check(slices.Contains([]string{"Create", "Update", "Delete"},
+		activity.Type), "unexpected activity type %q", activity.Type)
+	check(isOurURL(activity.Actor), "bad actor %q", activity.Actor)
+
+	object, ok := activity.Object.(*ap.Object)
+	if !ok {
+		return errors.New("activity has no object")
+	}
+	check(isOurURL(object.Id), "bad object id %q", object.Id)
+	if activity.Type == "Delete" {
+		check(object.Type == "Tombstone", "deleted object is not a Tombstone")
+		return errors.Join(errs...)
+	}
+
+	check(object.Type == "Note" || object.Type == "Article",
+		"unexpected object type %q", object.Type)
+	check(object.Type != "Article" || object.Name != "", "article has no name")
+	check(strings.TrimSpace(object.Content) != "", "object has no content")
+	check(object.URL == object.Id, "object url doesn't match its id")
+	check(object.AttributedTo == activity.Actor, "object not attributed to actor")
+	_, err := time.Parse(time.RFC3339, object.Published)
+	check(err == nil, "bad published date %q", object.Published)
+	if activity.Type == "Update" {
+		_, err := time.Parse(time.RFC3339, object.Updated)
+		check(err == nil, "bad updated date %q", object.Updated)
+	}
+	for _, attachment := range object.Attachment {
+		check(isOurURL(attachment.URL), "bad attachment url %q", attachment.URL)
+		check(attachment.MediaType != "", "attachment %s has no media type",
+			attachment.URL)
+	}
+	for _, tag := range object.Tag {
+		check(len(tag.Name) > 1, "empty hashtag")
+		check(isOurURL(tag.Href), "bad hashtag link %q", tag.Href)
+	}
+
+	return errors.Join(errs...)
+}
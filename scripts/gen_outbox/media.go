@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	"golang.org/x/net/html"
+)
+
+// Mastodon shows at most four attachments on a post
+const maxAttachments = 4
+
+// Formats fediverse servers will display inline
+var attachableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// A page resource declared in front matter, used to give bundled images a
+// description:
+//
+//	[[resources]]
+//	src = "bryce-canyon.jpg"
+//	[resources.params]
+//	alt = "Hoodoos at sunrise"
+type pageResource struct {
+	Src    string         `toml:"src"`
+	Title  string         `toml:"title"`
+	Params map[string]any `toml:"params"`
+}
+
+func (r *pageResource) alt() string {
+	if alt, ok := r.Params["alt"].(string); ok && alt != "" {
+		return alt
+	}
+	return r.Title
+}
+
+// Collects the post's images as attachments: first those listed in the
+// `images` front matter, which Hugo also uses for social cards and so are
+// the hero images, then any shown in the post body or declared as page
+// resources. Alt text comes from the resource params or else the img tag.
+func buildAttachments(p *post, host, publicDir string) ([]ap.Attachment, error) {
+	permalinkPath := "/posts/" + p.slug + "/"
+	bodyImages := imagesInContent(p.contentHTML)
+
+	alts := make(map[string]string)
+	for _, img := range bodyImages {
+		alts[resolvePath(img.src, permalinkPath)] = img.alt
+	}
+	var declared []string
+	for _, res := range p.Resources {
+		urlPath := resolvePath(res.Src, permalinkPath)
+		if alt := res.alt(); alt != "" {
+			alts[urlPath] = alt
+		}
+		declared = append(declared, res.Src)
+	}
+
+	var sources []string
+	sources = append(sources, p.Images...)
+	for _, img := range bodyImages {
+		sources = append(sources, img.src)
+	}
+	sources = append(sources, declared...)
+
+	seen := make(map[string]bool)
+	var attachments []ap.Attachment
+	for _, src := range sources {
+		if len(attachments) == maxAttachments {
+			break
+		}
+		u, err := url.Parse(src)
+		if err != nil || (u.Scheme != "" || u.Host != "") &&
+			!strings.HasPrefix(src, host+"/") {
+			// only our own files can be measured
+			continue
+		}
+		urlPath := resolvePath(strings.TrimPrefix(src, host), permalinkPath)
+		if seen[urlPath] {
+			continue
+		}
+		seen[urlPath] = true
+
+		mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(urlPath)))
+		if !attachableTypes[mediaType] {
+			continue
+		}
+		attachment := ap.Attachment{
+			Type:      "Image",
+			MediaType: mediaType,
+			URL:       host + urlPath,
+			Name:      alts[urlPath],
+		}
+		err = measureImage(&attachment, findMediaFile(p, urlPath, publicDir))
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %w", src, err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+type contentImage struct {
+	src, alt string
+}
+
+func imagesInContent(fragment string) []contentImage {
+	var images []contentImage
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return images
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data != "img" {
+				continue
+			}
+			var img contentImage
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "src":
+					img.src = attr.Val
+				case "alt":
+					img.alt = attr.Val
+				}
+			}
+			if img.src != "" {
+				images = append(images, img)
+			}
+		}
+	}
+}
+
+// Site-absolute path of a link from the post's page
+func resolvePath(src, permalinkPath string) string {
+	if u, err := url.Parse(src); err == nil {
+		src = u.Path
+	}
+	if strings.HasPrefix(src, "/") {
+		return path.Clean(src)
+	}
+	return path.Join(permalinkPath, src)
+}
+
+// Hugo only publishes bundle resources that a template uses, so fall back to
+// the copy next to the post's source
+func findMediaFile(p *post, urlPath, publicDir string) string {
+	published := filepath.Join(publicDir, filepath.FromSlash(urlPath))
+	if _, err := os.Stat(published); err == nil {
+		return published
+	}
+	bundlePrefix := "/posts/" + p.slug + "/"
+	if filepath.Base(p.sourcePath) == "index.md" &&
+		strings.HasPrefix(urlPath, bundlePrefix) {
+		return filepath.Join(filepath.Dir(p.sourcePath),
+			filepath.FromSlash(strings.TrimPrefix(urlPath, bundlePrefix)))
+	}
+	return published
+}
+
+// Fills in the image's dimensions and blurhash. WebP can't be decoded by the
+// standard library, so those go out without them.
+func measureImage(attachment *ap.Attachment, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if attachment.MediaType == "image/webp" {
+		return nil
+	}
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+	attachment.Width = img.Bounds().Dx()
+	attachment.Height = img.Bounds().Dy()
+	// the component counts Mastodon uses for landscape and portrait images
+	if attachment.Width >= attachment.Height {
+		attachment.Blurhash = encodeBlurhash(img, 4, 3)
+	} else {
+		attachment.Blurhash = encodeBlurhash(img, 3, 4)
+	}
+	return nil
+}
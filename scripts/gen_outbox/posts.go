@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/maxbanister/blog/netlify/config"
+	"golang.org/x/net/html"
+)
+
+type siteConfig struct {
+	BaseURL      string `toml:"baseURL"`
+	LanguageCode string `toml:"languageCode"`
+	Title        string `toml:"title"`
+	Theme        string `toml:"theme"`
+}
+
+type frontMatter struct {
+	Title      string   `toml:"title"`
+	Date       any      `toml:"date"`
+	Lastmod    any      `toml:"lastmod"`
+	ExpiryDate any      `toml:"expiryDate"`
+	Draft      bool     `toml:"draft"`
+	Tags       []string `toml:"tags"`
+	Summary    string   `toml:"summary"`
+	Slug       string   `toml:"slug"`
+	Images     []string `toml:"images"`
+	// alt text for bundled images
+	Resources []pageResource `toml:"resources"`
+	// "Note" or "Article"; by default long posts are Articles
+	ActivityType string `toml:"activityType"`
+	// username of the local actor publishing the post, if not the default
+	Actor string `toml:"actor"`
+}
+
+type post struct {
+	frontMatter
+	sourcePath  string
+	slug        string
+	date        time.Time
+	lastmod     time.Time
+	expiry      time.Time
+	contentHTML string
+}
+
+func readSiteConfig(siteDir string) (*siteConfig, error) {
+	var cfg siteConfig
+	_, err := toml.DecodeFile(filepath.Join(siteDir, "hugo.toml"), &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not read hugo.toml: %w", err)
+	}
+	if cfg.BaseURL == "" {
+		return nil, errors.New("hugo.toml has no baseURL")
+	}
+	// the functions federate as the configured site, so the activities
+	// have to be addressed from it too
+	if strings.TrimSuffix(cfg.BaseURL, "/") != config.Site.BaseURL {
+		return nil, fmt.Errorf("hugo.toml baseURL %s doesn't match base_url %s",
+			cfg.BaseURL, config.Site.BaseURL)
+	}
+	return &cfg, nil
+}
+
+// Finds every post under content/posts, including those supplied by the
+// theme. Like Hugo, a site file shadows a theme file at the same path.
+func loadPosts(siteDir, publicDir string, cfg *siteConfig) ([]*post, error) {
+	contentDirs := []string{filepath.Join(siteDir, "content")}
+	if cfg.Theme != "" {
+		contentDirs = append(contentDirs,
+			filepath.Join(siteDir, "themes", cfg.Theme, "content"))
+	}
+
+	seen := make(map[string]bool)
+	var posts []*post
+	for _, contentDir := range contentDirs {
+		postsDir := filepath.Join(contentDir, "posts")
+		err := filepath.WalkDir(postsDir, func(p string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(p) != ".md" || d.Name() == "_index.md" {
+				return nil
+			}
+			relPath, _ := filepath.Rel(contentDir, p)
+			if seen[relPath] {
+				return nil
+			}
+			seen[relPath] = true
+
+			post, err := readPost(p, siteDir, publicDir)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			if post != nil {
+				posts = append(posts, post)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return posts, nil
+}
+
+// Returns nil for posts Hugo wouldn't publish
+func readPost(sourcePath, siteDir, publicDir string) (*post, error) {
+	source, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	fmText, ok := extractFrontMatter(source)
+	if !ok {
+		return nil, errors.New("only TOML front matter (+++) is supported")
+	}
+	p := &post{sourcePath: sourcePath}
+	_, err = toml.Decode(fmText, &p.frontMatter)
+	if err != nil {
+		return nil, fmt.Errorf("bad front matter: %w", err)
+	}
+	if p.Draft {
+		return nil, nil
+	}
+
+	p.slug = p.Slug
+	if p.slug == "" {
+		p.slug = strings.TrimSuffix(filepath.Base(sourcePath), ".md")
+		if p.slug == "index" {
+			// page bundle, named after its directory
+			p.slug = filepath.Base(filepath.Dir(sourcePath))
+		}
+	}
+
+	// mirror the [frontmatter] date settings in hugo.toml
+	fileInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	p.lastmod, err = parseDate(p.Lastmod)
+	if err != nil {
+		return nil, fmt.Errorf("bad lastmod: %w", err)
+	}
+	if p.lastmod.IsZero() {
+		p.lastmod = gitLastmod(siteDir, sourcePath)
+	}
+	if p.lastmod.IsZero() {
+		p.lastmod = fileInfo.ModTime()
+	}
+	p.date, err = parseDate(p.Date)
+	if err != nil {
+		return nil, fmt.Errorf("bad date: %w", err)
+	}
+	if p.date.IsZero() {
+		p.date = fileInfo.ModTime()
+	}
+	p.expiry, err = parseDate(p.ExpiryDate)
+	if err != nil {
+		return nil, fmt.Errorf("bad expiryDate: %w", err)
+	}
+	if p.date.After(time.Now()) {
+		// Hugo doesn't build future posts
+		return nil, nil
+	}
+	if p.isExpired() {
+		// expired posts aren't rendered, we only need to announce the delete
+		return p, nil
+	}
+
+	renderedPath := filepath.Join(publicDir, "posts", p.slug, "index.html")
+	rendered, err := os.ReadFile(renderedPath)
+	if err != nil {
+		return nil, fmt.Errorf("rendered post missing, run hugo first: %w", err)
+	}
+	p.contentHTML, err = extractContent(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", renderedPath, err)
+	}
+
+	return p, nil
+}
+
+func (p *post) isExpired() bool {
+	return !p.expiry.IsZero() && !p.expiry.After(time.Now())
+}
+
+func extractFrontMatter(source []byte) (string, bool) {
+	source = bytes.TrimLeft(source, "\ufeff\r\n ")
+	rest, found := bytes.CutPrefix(source, []byte("+++"))
+	if !found {
+		return "", false
+	}
+	fm, _, found := bytes.Cut(rest, []byte("\n+++"))
+	if !found {
+		return "", false
+	}
+	return string(fm), true
+}
+
+func parseDate(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case string:
+		// Hugo reads dates without a zone as UTC
+		for _, layout := range []string{
+			time.RFC3339, "2006-01-02T15:04:05", "2006-01-02",
+		} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unrecognized date %q", v)
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %v", value)
+}
+
+func gitLastmod(siteDir, sourcePath string) time.Time {
+	absPath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return time.Time{}
+	}
+	cmd := exec.Command("git", "log", "-1", "--format=%cI", "--", absPath)
+	cmd.Dir = siteDir
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+	return t
+}
+
+// Pulls the rendered body of the post out of the e-content element of its
+// page, leaving behind the site chrome
+func extractContent(page []byte) (string, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+	contentNode := findByClass(doc, "e-content")
+	if contentNode == nil {
+		return "", errors.New("no e-content element found")
+	}
+	var buf bytes.Buffer
+	for child := contentNode.FirstChild; child != nil; child = child.NextSibling {
+		if err := html.Render(&buf, child); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func findByClass(n *html.Node, class string) *html.Node {
+	if n.Type == html.ElementNode {
+		for _, attr := range n.Attr {
+			if attr.Key == "class" && hasClass(attr.Val, class) {
+				return n
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findByClass(child, class); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func hasClass(classAttr, class string) bool {
+	for _, c := range strings.Fields(classAttr) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Plain text of an HTML fragment, with whitespace collapsed
+func htmlToText(fragment string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	var text strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case html.TextToken:
+			text.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			// keep words in adjacent blocks apart
+			tagName, _ := tokenizer.TagName()
+			switch string(tagName) {
+			case "p", "br", "div", "li", "blockquote", "hr", "td", "th",
+				"h1", "h2", "h3", "h4", "h5", "h6", "figcaption", "pre":
+				text.WriteByte(' ')
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Moves a local actor's followers to another account. First add the actor's
+// ID to the new account's aliases (alsoKnownAs), set moved_to in site.toml to
+// the new account's ID and deploy. Then, from this directory,
+//
+//	go run . [-actor name] <new account ID>
+//
+// sends a Move to every follower, whose servers follow the new account in
+// their place.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/kv"
+)
+
+func main() {
+	actorName := flag.String("actor", "", "username of the local actor to move "+
+		"(default "+ap.DefaultActor().Username+")")
+	flag.Parse()
+	from := ap.DefaultActor()
+	if *actorName != "" {
+		from = ap.FindLocalActor(*actorName)
+		if from == nil {
+			fmt.Println("no local actor named", *actorName)
+			os.Exit(1)
+		}
+	}
+	target := flag.Arg(0)
+	if target == "" {
+		fmt.Println("usage: move_actor [-actor name] <new account ID>")
+		os.Exit(1)
+	}
+
+	err := moveActor(from, target)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func moveActor(from *ap.LocalActor, target string) error {
+	if from.MovedTo != target {
+		return fmt.Errorf("set moved_to for %s to %s in site.toml and deploy first",
+			from.Username, target)
+	}
+
+	// the target is fetched with the site's key
+	signers := []*ap.LocalActor{from}
+	if from != ap.SiteSigner() {
+		signers = append(signers, ap.SiteSigner())
+	}
+	for _, signer := range signers {
+		keyFile := "../../" + signer.PrivateKeyFile()
+		privKey, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("could not find and read %s: %w", keyFile, err)
+		}
+		keyEnv := signer.SigningKey().PrivateKeyEnv
+		os.Setenv(keyEnv, string(privKey))
+		defer os.Unsetenv(keyEnv)
+	}
+
+	// servers refuse a Move the new account doesn't vouch for
+	targetObj, err := ap.GetObject(target)
+	if err != nil {
+		return fmt.Errorf("could not fetch %s: %w", target, err)
+	}
+	if !slices.Contains(ap.ToStringSlice(targetObj["alsoKnownAs"]), from.ID()) {
+		return fmt.Errorf("add %s to the aliases of %s first", from.ID(), target)
+	}
+
+	ctx := context.Background()
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+	followers, err := kv.GetFollowers(ctx, client, from)
+	if err != nil {
+		return err
+	}
+
+	move := ap.NewMove(from, target)
+	payload, err := move.Payload()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, follower := range followers {
+		err := ap.SendActivity(payload, follower)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", follower.Id, err))
+		}
+	}
+	fmt.Printf("Sent the Move to %d of %d followers\n",
+		len(followers)-len(errs), len(followers))
+
+	err = kv.SaveActivity(move, payload)
+	if err != nil {
+		fmt.Println("warning: could not save activity:", err.Error())
+	}
+	return errors.Join(errs...)
+}
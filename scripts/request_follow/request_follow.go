@@ -1,39 +1,75 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/kv"
 )
 
 func main() {
-	userURL := os.Args[1]
+	actorName := flag.String("actor", "", "username of the local actor to send as "+
+		"(default "+ap.DefaultActor().Username+")")
+	// the script only checks the actor can be fetched unless told to send
+	send := flag.Bool("send", false, "send the Follow")
+	undo := flag.Bool("undo", false, "unfollow instead, by sending an Undo "+
+		"of the Follow")
+	flag.Parse()
+	from := ap.DefaultActor()
+	if *actorName != "" {
+		from = ap.FindLocalActor(*actorName)
+		if from == nil {
+			fmt.Println("no local actor named", *actorName)
+			return
+		}
+	}
+	userURL := flag.Arg(0)
 	fmt.Println("Attempting to follow", userURL)
 
-	priv_key_contents, err := os.ReadFile("../../private.pem")
+	keyFile := "../../" + from.PrivateKeyFile()
+	priv_key_contents, err := os.ReadFile(keyFile)
 	if err != nil {
-		fmt.Println("could not find and read private.pem", err.Error())
+		fmt.Println("could not find and read", keyFile, err.Error())
 		return
 	}
 
-	os.Setenv("AP_PRIVATE_KEY", string(priv_key_contents))
+	os.Setenv(from.SigningKey().PrivateKeyEnv, string(priv_key_contents))
+	if from != ap.SiteSigner() {
+		// the actor is fetched with the site's key
+		siteKeyFile := "../../" + ap.SiteSigner().PrivateKeyFile()
+		siteKey, err := os.ReadFile(siteKeyFile)
+		if err != nil {
+			fmt.Println("could not find and read", siteKeyFile, err.Error())
+			return
+		}
+		siteKeyEnv := ap.SiteSigner().SigningKey().PrivateKeyEnv
+		os.Setenv(siteKeyEnv, string(siteKey))
+		defer os.Unsetenv(siteKeyEnv)
+	}
 
 	actor, err := ap.FetchActorAuthorized(userURL)
 	if err != nil {
 		fmt.Println("could not fetch actor:", err.Error())
 		return
 	}
-	return
+	if !*send {
+		fmt.Println("Fetched", actor.Id, "- pass -send to follow")
+		return
+	}
 
-	payload := fmt.Sprintf(`{
-		"@context": "https://www.w3.org/ns/activitystreams",
-		"id": "https://maxbanister.com/follows/%s",
-		"type": "Follow",
-		"actor": "https://maxbanister.com/ap/user/max",
-		"object": "%s"%s`, randomBase16String(), userURL, "\n}\n")
+	// servers match an Undo to the follow by its actor and object, so a
+	// fresh Follow does for the one being undone
+	activity := ap.NewFollow(from, actor)
+	if *undo {
+		activity = ap.NewUndo(activity)
+	}
+	payload, err := activity.Payload()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
 	err = ap.SendActivity(payload, actor)
 	if err != nil {
@@ -41,13 +77,16 @@ func main() {
 		return
 	}
 
-	os.Unsetenv("AP_PRIVATE_KEY")
+	os.Unsetenv(from.SigningKey().PrivateKeyEnv)
 
-	fmt.Println("Successfully sent follow - check inbox for AcceptFollow")
-}
+	err = kv.SaveActivity(activity, payload)
+	if err != nil {
+		fmt.Println("warning: could not save activity:", err.Error())
+	}
 
-func randomBase16String() string {
-	buff := make([]byte, 16)
-	rand.Read(buff)
-	return hex.EncodeToString(buff)
+	if *undo {
+		fmt.Println("Successfully sent unfollow")
+		return
+	}
+	fmt.Println("Successfully sent follow - check inbox for AcceptFollow")
 }
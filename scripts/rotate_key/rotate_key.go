@@ -0,0 +1,290 @@
+// Rotates a local actor's signing key. Run it from this directory, in two
+// steps around a deploy:
+//
+//	go run . [-actor name | -instance] [-overlap 168h]
+//
+// generates a new keypair, makes it the actor's signing key in keys.toml and
+// keeps the old key published for the overlap period. Set the environment
+// variable it names to the new private key, commit keys.toml and deploy. The
+// deploy sends an Update of the actor to its followers, so they refetch the
+// key; should that fail,
+//
+//	go run . -announce [-actor name]
+//
+// sends it again.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
+	"github.com/maxbanister/blog/netlify/kv"
+)
+
+const keysFile = "../../keys.toml"
+
+const keysHeader = `# Signing keys of the actors in site.toml, maintained by scripts/rotate_key.
+# An actor's first key signs everything it sends. The keys after it were
+# rotated out, and are still published until retire_after so that servers
+# can verify what was signed with them before the rotation.
+`
+
+func main() {
+	actorName := flag.String("actor", "", "username of the local actor whose key "+
+		"to rotate (default "+ap.DefaultActor().Username+")")
+	overlap := flag.Duration("overlap", 7*24*time.Hour, "how long the old key "+
+		"stays published")
+	announce := flag.Bool("announce", false, "send the deployed actor, with "+
+		"its new key, to its followers")
+	instance := flag.Bool("instance", false, "rotate the instance actor's key, "+
+		"or give it its first")
+	flag.Parse()
+	from := ap.DefaultActor()
+	if *instance {
+		from = ap.InstanceActor
+	} else if *actorName != "" {
+		from = ap.FindLocalActor(*actorName)
+		if from == nil {
+			fmt.Println("no local actor named", *actorName)
+			os.Exit(1)
+		}
+	}
+
+	var err error
+	if *announce {
+		err = announceKey(from)
+	} else {
+		err = rotateKey(from, *overlap)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func rotateKey(actor *ap.LocalActor, overlap time.Duration) error {
+	var keys config.KeysFile
+	_, err := toml.DecodeFile(keysFile, &keys)
+	if err != nil {
+		return fmt.Errorf("could not read keys.toml: %w", err)
+	}
+
+	now := time.Now().UTC()
+	// the instance actor is named after the domain, which has dots
+	envUsername := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, actor.Username)
+	newKey := config.Key{
+		Actor: actor.Username,
+		ID:    "key-" + now.Format("20060102"),
+		PrivateKeyEnv: "AP_PRIVATE_KEY_" + envUsername + "_" +
+			now.Format("20060102"),
+	}
+	for _, key := range actor.Keys {
+		if key.ID == newKey.ID {
+			return fmt.Errorf("%s already has a key from today", actor.Username)
+		}
+	}
+
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("could not generate key: %w", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return fmt.Errorf("could not encode private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		return fmt.Errorf("could not encode public key: %w", err)
+	}
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	newKey.PublicKeyPEM = strings.TrimSpace(string(pem.EncodeToMemory(
+		&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})))
+
+	// the new key goes first so it signs; the current one is published until
+	// the overlap ends, and keys whose overlap has already ended are dropped
+	var rotated []config.Key
+	inserted := false
+	for _, key := range keys.Keys {
+		if !strings.EqualFold(key.Actor, actor.Username) {
+			rotated = append(rotated, key)
+			continue
+		}
+		if !inserted {
+			rotated = append(rotated, newKey)
+			inserted = true
+			key.RetireAfter = now.Add(overlap)
+		} else if now.After(key.RetireAfter) {
+			continue
+		}
+		rotated = append(rotated, key)
+	}
+	if !inserted {
+		// the instance actor's first key
+		rotated = append(rotated, newKey)
+	}
+
+	// where the scripts will read the signing key; see
+	// LocalActor.PrivateKeyFile
+	keyFile := "../../private-" + actor.Username + "-" + newKey.ID + ".pem"
+	err = os.WriteFile(keyFile, privPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("could not save private key: %w", err)
+	}
+	err = writeKeys(rotated)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Saved the new private key to", keyFile)
+	fmt.Println("Next:")
+	fmt.Printf("  1. set %s to the contents of %s\n", newKey.PrivateKeyEnv, keyFile)
+	if actor == ap.InstanceActor {
+		// it has no followers to tell
+		fmt.Println("  2. commit keys.toml and deploy")
+	} else {
+		fmt.Println("  2. commit keys.toml and deploy, which announces the new key")
+		fmt.Printf("  3. if followers missed it, go run . -announce -actor %s\n",
+			actor.Username)
+	}
+	if inserted {
+		fmt.Printf("The old key is published until %s, after which its "+
+			"environment variable can be removed.\n",
+			now.Add(overlap).Format(time.RFC3339))
+	}
+	return nil
+}
+
+func writeKeys(keys []config.Key) error {
+	var b strings.Builder
+	b.WriteString(keysHeader)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n[[keys]]\nactor = %q\nid = %q\n", key.Actor, key.ID)
+		if key.PrivateKeyEnv != "" {
+			fmt.Fprintf(&b, "private_key_env = %q\n", key.PrivateKeyEnv)
+		}
+		if !key.RetireAfter.IsZero() {
+			fmt.Fprintf(&b, "retire_after = %s\n",
+				key.RetireAfter.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(&b, "public_key_pem = \"\"\"\n%s\"\"\"\n",
+			strings.TrimSpace(key.PublicKeyPEM))
+	}
+	err := os.WriteFile(keysFile, []byte(b.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write keys.toml: %w", err)
+	}
+	return nil
+}
+
+// Sends an Update of the actor to its followers, once the deployed actor
+// carries the key this tree signs with
+func announceKey(actor *ap.LocalActor) error {
+	if actor == ap.InstanceActor {
+		return errors.New("the instance actor has no followers to announce to")
+	}
+	deployedKey, err := fetchDeployedKeyID(actor)
+	if err != nil {
+		return err
+	}
+	if deployedKey != actor.KeyID() {
+		return fmt.Errorf("the deployed actor signs with %s, not %s; deploy first",
+			deployedKey, actor.KeyID())
+	}
+
+	keyFile := "../../" + actor.PrivateKeyFile()
+	privKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("could not find and read %s: %w", keyFile, err)
+	}
+	keyEnv := actor.SigningKey().PrivateKeyEnv
+	os.Setenv(keyEnv, string(privKey))
+	defer os.Unsetenv(keyEnv)
+
+	ctx := context.Background()
+	client, err := kv.GetFirestoreClient()
+	if err != nil {
+		return fmt.Errorf("could not start firestore client: %w", err)
+	}
+	defer client.Close()
+	followers, err := kv.GetFollowers(ctx, client, actor)
+	if err != nil {
+		return err
+	}
+
+	update := ap.NewUpdate(actor, actor.Document())
+	payload, err := update.Payload()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, follower := range followers {
+		err := ap.SendActivity(payload, follower)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", follower.Id, err))
+		}
+	}
+	fmt.Printf("Sent the Update to %d of %d followers\n",
+		len(followers)-len(errs), len(followers))
+
+	err = kv.SaveActivity(update, payload)
+	if err != nil {
+		fmt.Println("warning: could not save activity:", err.Error())
+	}
+	return errors.Join(errs...)
+}
+
+func fetchDeployedKeyID(actor *ap.LocalActor) (string, error) {
+	req, err := http.NewRequest("GET", actor.ID(), nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/activity+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch the deployed actor: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var deployed struct {
+		PublicKey json.RawMessage `json:"publicKey"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&deployed)
+	if err != nil {
+		return "", fmt.Errorf("could not decode the deployed actor: %w", err)
+	}
+	// one key, or the signing key followed by those being retired
+	var keys []struct {
+		Id string `json:"id"`
+	}
+	if json.Unmarshal(deployed.PublicKey, &keys) != nil {
+		var key struct {
+			Id string `json:"id"`
+		}
+		json.Unmarshal(deployed.PublicKey, &key)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return "", errors.New("the deployed actor has no key")
+	}
+	return keys[0].Id, nil
+}
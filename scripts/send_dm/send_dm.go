@@ -5,15 +5,30 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/maxbanister/blog/netlify/ap"
+	"github.com/maxbanister/blog/netlify/config"
+	"github.com/maxbanister/blog/netlify/kv"
+	. "github.com/maxbanister/blog/netlify/util"
 )
 
 func main() {
-	userURL := os.Args[1]
+	actorName := flag.String("actor", "", "username of the local actor to send as "+
+		"(default "+ap.DefaultActor().Username+")")
+	flag.Parse()
+	from := ap.DefaultActor()
+	if *actorName != "" {
+		from = ap.FindLocalActor(*actorName)
+		if from == nil {
+			fmt.Println("no local actor named", *actorName)
+			return
+		}
+	}
+	userURL := flag.Arg(0)
 	fmt.Println("Sending message to", userURL)
 
 	req, err := http.NewRequest("GET", userURL, bytes.NewBuffer([]byte{}))
@@ -36,43 +51,29 @@ func main() {
 		return
 	}
 
-	randVal := randomBase16String()
-	payload := fmt.Sprintf(`{
-		"@context": [
-		  "https://www.w3.org/ns/activitystreams"
-		],
-		"id": "https://maxbanister.com/posts/%s#create",
-		"type": "Create",
-		"actor": "https://maxbanister.com/ap/user/max",
-		"published": "2025-05-03T22:46:52Z",
-		"to": [
-		  "%s"
-		],
-		"cc": [],
-		"object": {
-		  "@context": "https://www.w3.org/ns/activitystreams",
-		  "id": "https://maxbanister.com/posts/%s",
-		  "type": "Note",
-		  "summary": null,
-		  "inReplyTo": null,
-		  "published": "2025-05-05T07:46:52Z",
-		  "url": "https://maxbanister.com/posts/%s",
-		  "attributedTo": "https://maxbanister.com/ap/user/max",
-		  "to": [
-			"%s"
-		  ],
-		  "cc": [],
-		  "content": "username maxbanister.com"
-		}
-	  }`, randVal, userURL, randVal, randVal, userURL)
+	postURL := GetHostSite() + "/posts/" + randomBase16String()
+	create := ap.NewCreate(from, map[string]any{
+		"id":      postURL,
+		"type":    "Note",
+		"url":     postURL,
+		"to":      []string{actor.Id},
+		"cc":      []string{},
+		"content": "username " + config.Site.SiteName,
+	})
+	payload, err := create.Payload()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
-	priv_key_contents, err := os.ReadFile("../../private.pem")
+	keyFile := "../../" + from.PrivateKeyFile()
+	priv_key_contents, err := os.ReadFile(keyFile)
 	if err != nil {
-		fmt.Println("could not find and read private.pem", err.Error())
+		fmt.Println("could not find and read", keyFile, err.Error())
 		return
 	}
 
-	os.Setenv("AP_PRIVATE_KEY", string(priv_key_contents))
+	os.Setenv(from.SigningKey().PrivateKeyEnv, string(priv_key_contents))
 
 	err = ap.SendActivity(payload, &actor)
 	if err != nil {
@@ -80,7 +81,12 @@ func main() {
 		return
 	}
 
-	os.Unsetenv("AP_PRIVATE_KEY")
+	os.Unsetenv(from.SigningKey().PrivateKeyEnv)
+
+	err = kv.SaveActivity(create, payload)
+	if err != nil {
+		fmt.Println("warning: could not save activity:", err.Error())
+	}
 
 	fmt.Println("Successfully sent message")
 }
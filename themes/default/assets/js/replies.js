@@ -53,7 +53,28 @@ async function renderReplies() {
 		"https://maxbanister.com" + window.location.pathname
 	);
 
-	const resp = await fetch(window.location.pathname + "replies");
+	const sortSelect = document.getElementById("reply-sort");
+	sortSelect.addEventListener("change", () => {
+		const repliesEl = document.getElementById("replies");
+		for (const el of repliesEl.querySelectorAll(":scope > .reply, :scope > .load-more")) {
+			el.remove();
+		}
+		loadReplies(repliesEl);
+	});
+
+	return loadReplies(document.getElementById("replies"));
+}
+
+// Fetches a page of replies, either the top of the thread or, given a
+// cursor, the rest of a branch that was cut short
+async function loadReplies(parentEl, cursor) {
+	// earlier versions of edited replies come too, for the edited marker
+	let url = window.location.pathname + "replies?history=true&sort=" +
+		document.getElementById("reply-sort").value;
+	if (cursor) {
+		url += "&cursor=" + encodeURIComponent(cursor);
+	}
+	const resp = await fetch(url);
 	if (!resp.ok) {
 		console.log(resp.statusText);
 		return;
@@ -61,14 +82,14 @@ async function renderReplies() {
 	let repliesData = await resp.json();
 	console.log(repliesData);
 
-	addRepliesRecursive(document.getElementById("replies"), repliesData.items);
+	addRepliesRecursive(parentEl, repliesData);
 }
 
-function addRepliesRecursive(parentEl, replyItems) {
-	if (!replyItems)
+function addRepliesRecursive(parentEl, collection) {
+	if (!collection)
 		return;
 
-	for (const item of replyItems) {
+	for (const item of collection.items || []) {
 		const deleted = item.type == "Tombstone";
 		item.url = deleted ? "javascript:void(0)"
 		                   : item.url.replace("https://fed.brid.gy/r/", "");
@@ -84,16 +105,34 @@ function addRepliesRecursive(parentEl, replyItems) {
 			date: item.published,
 			editDate: item.updated,
 			opURL: item.url,
-			content: item.content
+			content: item.content,
+			summary: item.summary,
+			sensitive: item.sensitive,
+			attachments: item.attachment,
+			tags: item.tag,
+			history: item.history
 		}, deleted);
 
-		addRepliesRecursive(newReply, item.replies.items);
+		addRepliesRecursive(newReply, item.replies);
+	}
+
+	if (collection.next) {
+		const loadMore = document.createElement("button");
+		loadMore.className = "load-more";
+		loadMore.textContent = "Load more replies";
+		loadMore.addEventListener("click", async () => {
+			loadMore.disabled = true;
+			await loadReplies(parentEl, collection.next);
+			loadMore.remove();
+		});
+		parentEl.appendChild(loadMore);
 	}
 }
 
 function createAndAddReply(parentEl, params, deleted) {
 	const {
-		id, name, shortName, host, picURL, userURL, date, editDate, opURL, content
+		id, name, shortName, host, picURL, userURL, date, editDate, opURL, content,
+		summary, sensitive, attachments, tags, history
 	} = params;
 
 	const options = {
@@ -102,7 +141,8 @@ function createAndAddReply(parentEl, params, deleted) {
 	};
 
 	let modifiedDate = new Intl.DateTimeFormat(undefined, options).format(new Date(date));
-	if (editDate) {
+	// replies with earlier versions get a marker to expand them instead
+	if (editDate && !(history && history.length)) {
 		const dateEdited = new Intl.DateTimeFormat(undefined, options).format(new Date(editDate));
 		modifiedDate += " (Edited: " + dateEdited + ")";
 	}
@@ -117,6 +157,22 @@ function createAndAddReply(parentEl, params, deleted) {
 
 	const contentEl = cloneEl.getElementsByClassName("reply-contents")[0];
 	contentEl.innerHTML = deleted ? "<i style=\"color: grey\">[deleted]</i>" : content;
+	if (!deleted) {
+		renderEmoji(contentEl, tags, id);
+		linkTags(contentEl, tags);
+		const gallery = renderAttachments(attachments, id);
+		if (gallery && sensitive && !summary) {
+			contentEl.append(collapse("Sensitive media", [gallery]));
+		} else if (gallery) {
+			contentEl.append(gallery);
+		}
+		if (summary) {
+			contentEl.append(collapse(summary, [...contentEl.childNodes]));
+		}
+		if (editDate && history && history.length) {
+			contentEl.append(renderHistory(editDate, history, tags, id, options));
+		}
+	}
 
 	const profileImage = clone.querySelector(".reply-top > img");
 	profileImage.src = deleted ? "" : "/image_proxy/" +
@@ -164,6 +220,159 @@ function createAndAddReply(parentEl, params, deleted) {
 	return cloneEl;
 }
 
+function mediaProxyURL(url, id) {
+	return "/image_proxy/" +
+		encodeURIComponent(url) +
+		"/media/" +
+		encodeURIComponent(id);
+}
+
+function isWebURL(url) {
+	return typeof url === "string" && /^https?:\/\//.test(url);
+}
+
+// Swap :shortcode: text for the custom emoji the origin server would show
+function renderEmoji(el, tags, id) {
+	const emoji = new Map();
+	for (const tag of tags || []) {
+		if (tag.type === "Emoji" && tag.icon && isWebURL(tag.icon.url)) {
+			emoji.set(tag.name.replace(/^:|:$/g, ""), tag.icon.url);
+		}
+	}
+	if (emoji.size === 0)
+		return;
+
+	const walker = document.createTreeWalker(el, NodeFilter.SHOW_TEXT);
+	const textNodes = [];
+	while (walker.nextNode()) {
+		textNodes.push(walker.currentNode);
+	}
+	for (const node of textNodes) {
+		const parts = node.textContent.split(/:([\w+-]+):/);
+		if (parts.length === 1)
+			continue;
+		const fragment = document.createDocumentFragment();
+		parts.forEach((part, i) => {
+			// odd parts are the shortcodes captured by the split
+			if (i % 2 === 0 || !emoji.has(part)) {
+				fragment.append(i % 2 === 0 ? part : ":" + part + ":");
+				return;
+			}
+			const img = document.createElement("img");
+			img.className = "custom-emoji";
+			img.src = mediaProxyURL(emoji.get(part), id);
+			img.alt = img.title = ":" + part + ":";
+			fragment.append(img);
+		});
+		node.replaceWith(fragment);
+	}
+}
+
+// Links in replies open on the origin server. Mentions show the full
+// user@host they refer to, since the link text only has the username.
+function linkTags(el, tags) {
+	const mentions = (tags || []).filter(t => t.type === "Mention" && t.name);
+	for (const anchor of el.querySelectorAll("a")) {
+		anchor.target = "_blank";
+		anchor.rel = "nofollow noopener noreferrer";
+
+		const text = anchor.textContent.trim();
+		if (!text.startsWith("@"))
+			continue;
+		const mention = mentions.find(m => {
+			const fullName = m.name.startsWith("@") ? m.name : "@" + m.name;
+			return fullName === text || fullName.startsWith(text + "@");
+		});
+		if (mention) {
+			anchor.classList.add("mention");
+			anchor.title = mention.name;
+			if (isWebURL(mention.href))
+				anchor.href = mention.href;
+		}
+	}
+}
+
+function renderAttachments(attachments, id) {
+	if (!attachments || attachments.length === 0)
+		return;
+
+	const gallery = document.createElement("div");
+	gallery.className = "reply-attachments";
+	for (const attachment of attachments) {
+		if (!isWebURL(attachment.url))
+			continue;
+		const link = document.createElement("a");
+		link.href = attachment.url;
+		link.target = "_blank";
+		link.rel = "nofollow noopener noreferrer";
+
+		const mediaType = attachment.mediaType || "";
+		if (attachment.type === "Image" || mediaType.startsWith("image/")) {
+			const img = document.createElement("img");
+			img.src = mediaProxyURL(attachment.url, id);
+			img.alt = img.title = attachment.name || "";
+			img.loading = "lazy";
+			if (attachment.width && attachment.height) {
+				img.width = attachment.width;
+				img.height = attachment.height;
+			}
+			link.append(img);
+		}
+		else {
+			// video, audio and the like are linked rather than embedded
+			link.textContent = "📎 " +
+				(attachment.name || attachment.url.split("/").pop());
+		}
+		gallery.append(link);
+	}
+	return gallery.childElementCount ? gallery : undefined;
+}
+
+// Marks a reply as edited, expanding into its earlier versions, newest first
+function renderHistory(editDate, history, tags, id, dateOptions) {
+	const format = d => new Intl.DateTimeFormat(undefined, dateOptions).format(new Date(d));
+	const details = document.createElement("details");
+	details.className = "reply-history";
+	const summaryEl = document.createElement("summary");
+	summaryEl.textContent = "Edited " + format(editDate);
+	details.append(summaryEl);
+
+	for (const revision of [...history].reverse()) {
+		const versionEl = document.createElement("div");
+		versionEl.className = "reply-revision";
+		const dateEl = document.createElement("span");
+		dateEl.className = "reply-revision-date";
+		dateEl.textContent = "Version from " + format(revision.updated);
+
+		const contentEl = document.createElement("div");
+		contentEl.innerHTML = revision.content;
+		renderEmoji(contentEl, tags, id);
+		linkTags(contentEl, tags);
+		const gallery = renderAttachments(revision.attachment, id);
+		if (gallery && revision.sensitive && !revision.summary) {
+			contentEl.append(collapse("Sensitive media", [gallery]));
+		} else if (gallery) {
+			contentEl.append(gallery);
+		}
+		if (revision.summary) {
+			contentEl.append(collapse(revision.summary, [...contentEl.childNodes]));
+		}
+		versionEl.append(dateEl, contentEl);
+		details.append(versionEl);
+	}
+	return details;
+}
+
+// Hides nodes behind a content warning the reader has to click through
+function collapse(warning, nodes) {
+	const details = document.createElement("details");
+	details.className = "reply-cw";
+	const summaryEl = document.createElement("summary");
+	summaryEl.textContent = warning;
+	details.append(summaryEl, ...nodes);
+	return details;
+}
+
 async function main() {
 	return renderReplies();
 }